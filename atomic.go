@@ -0,0 +1,69 @@
+package pdftext
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Default permissions of what pdftext writes
+const (
+	defaultFileMode os.FileMode = 0644
+	defaultDirMode  os.FileMode = 0755
+)
+
+// writeFileAtomic writes data to path with permissions perm, so that path
+// never holds part of it: the data goes to a temporary file in the same
+// directory, which is synced and then renamed over path.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	return writeFile(path, data, perm, false)
+}
+
+// writeNewFileAtomic is writeFileAtomic for a path that must not exist
+// yet. If it does, it is left alone and the error satisfies os.IsExist.
+func writeNewFileAtomic(path string, data []byte, perm os.FileMode) error {
+	return writeFile(path, data, perm, true)
+}
+
+// writeFile writes data to path through a temporary file, which is linked
+// at path if it must be new and otherwise renamed over it.
+func writeFile(path string, data []byte, perm os.FileMode, exclusive bool) error {
+	dir, base := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	f, err := ioutil.TempFile(dir, "."+base+".tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp)
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp, perm)
+	}
+	if err != nil {
+		return err
+	}
+	if exclusive {
+		// Unlike a rename, a link fails rather than replace path
+		err = os.Link(tmp, path)
+	} else {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		return err
+	}
+	// Make the rename itself durable, where directories can be synced
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package pdftext
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "atomic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "tags.json")
+	ioutil.WriteFile(path, []byte("old"), 0666)
+	if err := writeFileAtomic(path, []byte("new"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	st, err := os.Stat(path)
+	if err != nil || st.Mode().Perm() != 0640 {
+		t.Errorf("got mode %v, %v", st.Mode(), err)
+	}
+	if data, _ := ioutil.ReadFile(path); string(data) != "new" {
+		t.Errorf("got %q", data)
+	}
+	if infos, _ := ioutil.ReadDir(dir); len(infos) != 1 {
+		t.Errorf("temporary files left: %d files", len(infos))
+	}
+	// A new file does not replace one already there
+	if err := writeNewFileAtomic(path, []byte("newer"), 0640); !os.IsExist(err) {
+		t.Errorf("got %v, want an error for an existing file", err)
+	}
+	if data, _ := ioutil.ReadFile(path); string(data) != "new" {
+		t.Errorf("got %q", data)
+	}
+	if infos, _ := ioutil.ReadDir(dir); len(infos) != 1 {
+		t.Errorf("temporary files left: %d files", len(infos))
+	}
+	// There is nowhere to put the temporary file
+	if err := writeFileAtomic(filepath.Join(dir, "missing", "x"), nil, 0644); err == nil {
+		t.Error("want an error writing into a missing directory")
+	}
+}
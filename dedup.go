@@ -0,0 +1,89 @@
+package pdftext
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// What to do with a PDF whose content is already in the output, set by
+// --duplicates. Whichever is chosen, the tag records the copy it
+// duplicates in DuplicateOf.
+const (
+	DupKeep = "keep" // File it under its own name as usual
+	DupSkip = "skip" // Leave it out of the output
+	DupLink = "link" // File it as a hard link to the existing copy
+)
+
+func checkDuplicates(policy string) error {
+	switch policy {
+	case DupKeep, DupSkip, DupLink:
+		return nil
+	}
+	return fmt.Errorf("unknown duplicates policy %q, want keep, skip or link", policy)
+}
+
+// duplicateOf returns where in the output the tag's content already is, if
+// anywhere: a PDF filed earlier in the run, or one that was there before
+// it. The tag's own PDF, if it is in the output, does not count.
+func (tag *OutputTag) duplicateOf() string {
+	p := tag.proc
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.seedHashes()
+	paths := p.hashes[tag.SHA256]
+	if len(paths) == 0 {
+		return ""
+	}
+	for _, path := range paths {
+		if !sameFile(path, tag.OriginalPDF) {
+			return path
+		}
+	}
+	return ""
+}
+
+// sameFile reports whether paths a and b are the same file.
+func sameFile(a, b string) bool {
+	sa, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	sb, err := os.Stat(b)
+	return err == nil && os.SameFile(sa, sb)
+}
+
+// addHash records that the tag's content is at its NewPDF.
+func (tag *OutputTag) addHash() {
+	if tag.SHA256 == "" {
+		return
+	}
+	p := tag.proc
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.seedHashes()
+	p.hashes[tag.SHA256] = append(p.hashes[tag.SHA256], tag.NewPDF)
+}
+
+// seedHashes hashes the PDFs already in the output directory, the first
+// time it is called. Call with p.mu held.
+func (p *Processor) seedHashes() {
+	if p.hashes != nil {
+		return
+	}
+	p.hashes = make(map[string][]string)
+	out := p.cfg.Output
+	if out == "" {
+		out = "."
+	}
+	filepath.Walk(out, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || !strings.HasSuffix(path, ".pdf") {
+			return nil
+		}
+		if hash, err := hashFile(path); err == nil {
+			p.hashes[hash] = append(p.hashes[hash], path)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,166 @@
+package pdftext
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDuplicates(t *testing.T) {
+	for _, policy := range []string{DupKeep, DupSkip, DupLink} {
+		dir, err := ioutil.TempDir("", "dedup")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		in, out := filepath.Join(dir, "in"), filepath.Join(dir, "out")
+		os.Mkdir(in, 0755)
+		os.Mkdir(out, 0755)
+		old := filepath.Join(out, "old.pdf")
+		ioutil.WriteFile(old, []byte("A"), 0644)
+		for name, data := range map[string]string{"a.pdf": "A", "b.pdf": "B", "c.pdf": "B"} {
+			ioutil.WriteFile(filepath.Join(in, name), []byte(data), 0644)
+		}
+
+		cfg := DefaultConfig()
+		cfg.Output = out
+		cfg.Duplicates = policy
+		p, err := NewProcessor(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tags := make(map[string]*OutputTag)
+		for _, name := range []string{"a.pdf", "b.pdf", "c.pdf"} {
+			r, err := p.ProcessFile(context.Background(), filepath.Join(in, name))
+			if err != nil || r.Err != nil {
+				t.Fatal(policy, err, r.Err)
+			}
+			tags[name] = r.OutputTag
+		}
+		a, b, c := tags["a.pdf"], tags["b.pdf"], tags["c.pdf"]
+		if a.SHA256 != hashBytes([]byte("A")) {
+			t.Errorf("%s: a hashed to %s", policy, a.SHA256)
+		}
+		want := filepath.Join(out, "b.pdf")
+		if a.DuplicateOf != old || b.DuplicateOf != "" || c.DuplicateOf != want {
+			t.Errorf("%s: duplicates %q %q %q", policy, a.DuplicateOf, b.DuplicateOf, c.DuplicateOf)
+		}
+
+		_, err = os.Stat(filepath.Join(out, "c.pdf"))
+		if written := err == nil; written != (policy != DupSkip) {
+			t.Errorf("%s: duplicate written %v", policy, written)
+		}
+		switch policy {
+		case DupSkip:
+			if a.NewPDF != old || c.NewPDF != want {
+				t.Errorf("skipped duplicates named %s and %s", a.NewPDF, c.NewPDF)
+			}
+		case DupLink:
+			st1, _ := os.Stat(want)
+			st2, err := os.Stat(c.NewPDF)
+			if err != nil || !os.SameFile(st1, st2) || c.Mode != ModeHardlink {
+				t.Errorf("%s is not a link to %s: %v", c.NewPDF, want, err)
+			}
+		}
+	}
+	if checkDuplicates("drop") == nil {
+		t.Error("want an error for an unknown policy")
+	}
+}
+
+// A second run over the same PDFs finds them already filed
+func TestDuplicatesRerun(t *testing.T) {
+	for _, policy := range []string{DupKeep, DupSkip, DupLink} {
+		dir, err := ioutil.TempDir("", "rerun")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		in, out := filepath.Join(dir, "in"), filepath.Join(dir, "out")
+		os.Mkdir(in, 0755)
+		ioutil.WriteFile(filepath.Join(in, "notes.pdf"), []byte("notes"), 0644)
+		for _, run := range []string{"run1", "run2"} {
+			cfg := DefaultConfig()
+			cfg.Output = out
+			cfg.Duplicates = policy
+			cfg.RunID = run
+			p, err := NewProcessor(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r, err := p.ProcessFile(context.Background(), filepath.Join(in, "notes.pdf"))
+			p.Close()
+			if err != nil || r.Err != nil {
+				t.Fatalf("%s %s: %v %v", policy, run, err, r.Err)
+			}
+			if want := filepath.Join(out, "notes.pdf"); r.NewPDF != want {
+				t.Errorf("%s %s: named %s, want %s", policy, run, r.NewPDF, want)
+			}
+		}
+		entries, err := readJournal(filepath.Join(out, journalName))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range entries {
+			if e.Run == "run2" {
+				t.Errorf("%s: second run journaled %s %s", policy, e.Op, e.Dest)
+			}
+		}
+	}
+}
+
+// PDFs found in the output directory are not duplicates of themselves,
+// including when the output is the working directory
+func TestDuplicatesInOutput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile("2019_02_20_10_11_12.pdf", []byte("scan"), 0644)
+	ioutil.WriteFile("notes.pdf", []byte("notes"), 0644)
+	ioutil.WriteFile("existing.pdf", []byte("copy"), 0644)
+	os.Mkdir("in", 0755)
+	ioutil.WriteFile(filepath.Join("in", "copy.pdf"), []byte("copy"), 0644)
+
+	for _, output := range []string{"", "."} {
+		cfg := DefaultConfig()
+		cfg.Output = output
+		cfg.Duplicates = DupSkip
+		cfg.DryRun = true
+		cfg.Rules = []Rule{{Name: "Fios", Terms: []string{"fios"}}}
+		p, err := NewProcessor(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{"2019_02_20_10_11_12.pdf", "notes.pdf", filepath.Join("in", "copy.pdf")} {
+			tag := p.newTag(name)
+			tag.SHA256, _ = hashFile(name)
+			tag.Text = "Fios bill Feb 20, 2019"
+			tag.processText()
+			if err := tag.file(); err != nil {
+				t.Fatal(err)
+			}
+			want := ""
+			if name == filepath.Join("in", "copy.pdf") {
+				want = "existing.pdf"
+			}
+			if tag.DuplicateOf != want {
+				t.Errorf("output %q: %s is a duplicate of %q, want %q", output, name, tag.DuplicateOf, want)
+			}
+			if name == "2019_02_20_10_11_12.pdf" && tag.NewPDF != "Fios-2019-Feb-20.pdf" {
+				t.Errorf("output %q: %s named %s", output, name, tag.NewPDF)
+			}
+		}
+	}
+}
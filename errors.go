@@ -0,0 +1,150 @@
+package pdftext
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// ErrorKind says what failed while processing a PDF.
+type ErrorKind string
+
+// The kinds of FileError
+const (
+	KindOpen      ErrorKind = "open"      // The PDF could not be opened or read
+	KindParse     ErrorKind = "parse"     // The PDF reader could not make sense of it
+	KindEncrypted ErrorKind = "encrypted" // The PDF needs a password
+	KindWrite     ErrorKind = "write"     // The new PDF or its text could not be written
+	KindSymlink   ErrorKind = "symlink"   // The symlink to the original could not be made
+	KindLink      ErrorKind = "link"      // The hard link to the original could not be made
+	KindMove      ErrorKind = "move"      // The original could not be moved, so was left
+	KindTimeout   ErrorKind = "timeout"   // The PDF took longer than the timeout allows
+	KindCanceled  ErrorKind = "canceled"  // Processing was cancelled before the PDF was done
+)
+
+// The stages of processing a PDF a FileError can happen in, besides the
+// extraction of page N, which is pageStage(N)
+const (
+	StageOpen    = "open"    // Opening the original
+	StageDecrypt = "decrypt" // Reading its structure, decrypting if need be
+	StageText    = "text"    // Writing the recovered text
+	StageRename  = "rename"  // Making the directory of the new name
+	StageCopy    = "copy"    // Copying, linking or moving the PDF to its new name
+	StageJournal = "journal" // Recording what was written, for undo
+)
+
+func pageStage(n int) string {
+	return fmt.Sprintf("page %d extract", n)
+}
+
+// FileError is a failure to process one PDF. Processing carries on with
+// the other files; Run lists the failures at the end and in errors.json.
+type FileError struct {
+	Kind    ErrorKind
+	Stage   string
+	Page    int    // The page that failed, or 0 for the whole PDF
+	Path    string // The file the operation was on
+	Err     error
+	Stack   string        // Where the PDF reader panicked, if it did
+	Elapsed time.Duration // Time spent on the PDF, or the page, before it failed
+}
+
+func (e *FileError) Error() string {
+	return fmt.Sprintf("%s %s: %s: %v", e.Stage, e.Path, e.Kind, e.Err)
+}
+
+// Unwrap returns the underlying error, e.g. an *os.PathError.
+func (e *FileError) Unwrap() error {
+	return e.Err
+}
+
+// fileError wraps a non-nil err as a FileError of kind k.
+func fileError(k ErrorKind, stage, path string, err error) error {
+	return &FileError{Kind: k, Stage: stage, Path: path, Err: err}
+}
+
+// noText reports whether err only means that the text of the PDF could not
+// be extracted, in which case the PDF is still filed, as notext-NAME. A PDF
+// that timed out is abandoned instead, and left where it is for a rerun.
+func noText(err error) bool {
+	fe, ok := err.(*FileError)
+	return ok && (fe.Kind == KindParse || fe.Kind == KindEncrypted)
+}
+
+// ctxError is the *FileError for the PDF file abandoned in stage because
+// its context ended with err.
+func ctxError(err error, stage string, page int, file string) error {
+	kind := KindCanceled
+	if err == context.DeadlineExceeded {
+		kind = KindTimeout
+	}
+	return &FileError{Kind: kind, Stage: stage, Page: page, Path: file, Err: err}
+}
+
+// summarize writes how many of total files failed, then each failure by
+// original file name, and returns an error if there were any.
+func summarize(w io.Writer, total int, failed map[string]error) error {
+	if len(failed) == 0 {
+		return nil
+	}
+	var names []string
+	for name := range failed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintf(w, "%d of %d files failed:\n", len(failed), total)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %s: %v\n", name, failed[name])
+	}
+	return fmt.Errorf("%d of %d files failed", len(failed), total)
+}
+
+// errorRecord is an entry of errors.json. File is the original PDF, so
+// the failed set can be run again with --files.
+type errorRecord struct {
+	File    string
+	Stage   string    `json:",omitempty"`
+	Class   ErrorKind `json:",omitempty"`
+	Path    string    `json:",omitempty"` // The file the failed operation was on
+	Error   string
+	Stack   string  `json:",omitempty"`
+	Elapsed float64 // Seconds
+}
+
+// writeErrors writes the failures, by original file name, to path as a
+// JSON list. Files may have failed pages as well as, or instead of, failing
+// outright. An empty list is written if there were none, replacing the
+// report of an earlier run. It is written atomically with permissions perm.
+func writeErrors(path string, failed map[string][]error, perm os.FileMode) error {
+	records := []errorRecord{}
+	for file, errs := range failed {
+		for _, err := range errs {
+			records = append(records, newErrorRecord(file, err))
+		}
+	}
+	sort.SliceStable(records, func(i, j int) bool { return records[i].File < records[j].File })
+	bytes, err := json.MarshalIndent(records, " ", "")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, bytes, perm)
+}
+
+func newErrorRecord(file string, err error) errorRecord {
+	record := errorRecord{File: file, Error: err.Error()}
+	var fe *FileError
+	if errors.As(err, &fe) {
+		record.Stage = fe.Stage
+		record.Class = fe.Kind
+		record.Path = fe.Path
+		record.Error = fe.Err.Error()
+		record.Stack = fe.Stack
+		record.Elapsed = fe.Elapsed.Seconds()
+	}
+	return record
+}
@@ -0,0 +1,126 @@
+package pdftext
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// How much of the text explain shows, and how much context around a hit
+const (
+	explainSnippet = 600
+	explainContext = 30
+)
+
+// explain classifies one PDF exactly as ProcessFile would and writes out
+// why it got the name it did: the text, each rule's hits and misses, the
+// dates considered and the final name.
+func (p *Processor) explain(w io.Writer, path string) error {
+	tag := p.newTag(path)
+	if err := tag.processFileAndRename(context.Background()); err != nil {
+		return err
+	}
+	p.explainTag(w, tag)
+	return nil
+}
+
+// explainTag names a tag whose text has been processed, writing out why as
+// explain does.
+func (p *Processor) explainTag(w io.Writer, tag *OutputTag) {
+	fmt.Fprintln(w, "File:", tag.OriginalPDF)
+	snippet := tag.Text
+	if len(snippet) > explainSnippet {
+		snippet = snippet[:explainSnippet] + "..."
+	}
+	fmt.Fprintf(w, "\nText (%d bytes):\n%s\n", len(tag.Text), indent(snippet))
+
+	fmt.Fprintln(w, "\nDates:")
+	date, attempts := traceFirstDate(tag.Text)
+	if len(attempts) == 0 {
+		fmt.Fprintln(w, "  no date found")
+	}
+	for _, a := range attempts {
+		where := "text"
+		if a.Squeezed {
+			where = "text without spaces"
+		}
+		if a.Format == "" {
+			fmt.Fprintf(w, "  %q in %s, as %q: no format parsed it\n", a.Found, where, a.Parsed)
+		} else {
+			fmt.Fprintf(w, "  %q in %s, as %q: parsed with %q\n", a.Found, where, a.Parsed, a.Format)
+		}
+	}
+	fmt.Fprintf(w, "  FirstDate %q\n", date)
+
+	fmt.Fprintln(w, "\nRules:")
+	m := newMatchText(tag.Text)
+	for _, r := range p.rules {
+		score, ok := r.score(tag.Tags)
+		hits := 0
+		for _, item := range r.Terms {
+			if tag.Tags[item] {
+				hits++
+			}
+		}
+		switch {
+		case ok:
+			fmt.Fprintf(w, "  %s: match, score %d\n", r.Name, score)
+		case hits == 0:
+			fmt.Fprintf(w, "  %s: no terms found\n", r.Name)
+			continue
+		default:
+			fmt.Fprintf(w, "  %s: %d of %d terms\n", r.Name, hits, len(r.Terms))
+		}
+		for _, t := range r.terms {
+			explainTerm(w, "hit ", "miss", t.spec, t, m)
+		}
+		for _, t := range r.exclude {
+			explainTerm(w, "excluded", "absent", "!"+t.spec, t, m)
+		}
+	}
+
+	tag.renameBase(w)
+	fmt.Fprintln(w)
+	if best, _ := p.bestRule(tag.Tags); best.Rule != nil {
+		fmt.Fprintf(w, "Chosen: %s, score %d\n", best.Rule.Name, best.Score)
+		if best.Rule.Category != "" || len(best.Rule.Labels) > 0 {
+			fmt.Fprintf(w, "Category: %s, labels %q\n", best.Rule.Category, best.Rule.Labels)
+		}
+	} else {
+		fmt.Fprintln(w, "Chosen: no rule matched")
+	}
+	if tag.RunnerUp != "" {
+		fmt.Fprintf(w, "Runner-up: %s, score %d\n", tag.RunnerUp, tag.RunnerUpScore)
+	}
+	fmt.Fprintln(w, "Name:", tag.NewPDF)
+}
+
+// explainTerm writes one term of a rule with the offset and context of its
+// first occurrence, if any, and the edit distance of a fuzzy match.
+func explainTerm(w io.Writer, found, missing, label string, t *term, m *matchText) {
+	start, end, dist := t.find(m)
+	if start < 0 {
+		fmt.Fprintf(w, "    %s %q\n", missing, label)
+		return
+	}
+	text := m.text
+	from, to := start-explainContext, end+explainContext
+	if from < 0 {
+		from = 0
+	}
+	if to > len(text) {
+		to = len(text)
+	}
+	context := strings.Join(strings.Fields(text[from:start]+"["+text[start:end]+"]"+text[end:to]), " ")
+	fuzzy := ""
+	if dist >= 0 {
+		fuzzy = fmt.Sprintf(" (fuzzy, distance %d)", dist)
+	}
+	fmt.Fprintf(w, "    %s %q @%d%s: ...%s...\n", found, label, start, fuzzy, context)
+}
+
+// indent prefixes each line of s with two spaces.
+func indent(s string) string {
+	return "  " + strings.Replace(s, "\n", "\n  ", -1)
+}
@@ -0,0 +1,67 @@
+package pdftext
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExplain(t *testing.T) {
+	out, err := ioutil.TempDir("", "explain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(out)
+	ioutil.WriteFile(filepath.Join(out, "Fios-2019-Feb-20.pdf"), nil, 0644)
+	cfg := DefaultConfig()
+	cfg.Output = out
+	cfg.Rules = []Rule{
+		{Name: "Fios", Terms: []string{"fios", "verizon"}, Exclude: []string{"refund"}},
+		{Name: "Fios-Tv", Terms: []string{"fios", "tv guide"}},
+		{Name: "Eversource"},
+	}
+	p, err := NewProcessor(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tag := p.newTag(filepath.Join("in", "2019_02_20_10_11_12.pdf"))
+	tag.Text = "Your Fios bill dated Feb 20, 2019 from Verizon"
+	tag.processText()
+	var buf bytes.Buffer
+	p.explainTag(&buf, tag)
+	for _, want := range []string{
+		`"Feb20,2019" in text without spaces, as "feb 20 2019": parsed with "Jan 2 2006"`,
+		`FirstDate "-2019-Feb-20"`,
+		`hit  "fios" @5: ...Your [Fios] bill`,
+		`hit  "verizon" @39: `,
+		`absent "!refund"`,
+		"Fios-Tv: 1 of 2 terms",
+		`miss "tv guide"`,
+		"Eversource: no terms found",
+		"Chosen: Fios, score",
+		// Fios-2019-Feb-20 is already in the output
+		"Name: " + filepath.Join(out, "Fios-2019-Feb-20-1.pdf"),
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("no %q in\n%s", want, buf.String())
+		}
+	}
+
+	// A tie is explained too, not printed elsewhere
+	cfg.Rules = []Rule{{Name: "Alpha", Terms: []string{"fios"}}, {Name: "Beta", Terms: []string{"fios"}}}
+	p, err = NewProcessor(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tag = p.newTag(filepath.Join("in", "2019_02_20_10_11_12.pdf"))
+	tag.Text = "Fios"
+	tag.processText()
+	buf.Reset()
+	p.explainTag(&buf, tag)
+	if !strings.Contains(buf.String(), "tie between Alpha and Beta") {
+		t.Errorf("no tie in\n%s", buf.String())
+	}
+}
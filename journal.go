@@ -0,0 +1,271 @@
+package pdftext
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// journalName is the journal of a run's file operations, kept in --output
+const journalName = "journal.jsonl"
+
+// The operations recorded in the journal
+const (
+	OpMkdir    = "mkdir"      // Dest is a directory the run created
+	OpText     = "text"       // Dest is a text file the run wrote
+	OpCopy     = ModeCopy     // Dest is a copy of Source
+	OpSymlink  = ModeSymlink  // Dest is a symlink to Source
+	OpHardlink = ModeHardlink // Dest is a hard link to Source
+	OpMove     = ModeMove     // Dest was moved from Source
+	OpUndone   = "undone"     // The run has been undone
+)
+
+// journalEntry is a line of the journal: one thing a run did to the file
+// system.
+type journalEntry struct {
+	Run    string
+	Time   time.Time
+	Op     string
+	Source string `json:",omitempty"`
+	Dest   string
+	Hash   string `json:",omitempty"` // SHA-256 of what was written to Dest
+}
+
+// journal appends entries for one run to a journal file, opened on the
+// first entry. It is safe for concurrent use.
+type journal struct {
+	path string
+	run  string
+	perm os.FileMode // Permissions of the file, if it is created
+
+	mu sync.Mutex
+	f  *os.File
+}
+
+// newRunID returns an ID for a run started at t, which sorts by time.
+func newRunID(t time.Time) string {
+	return t.Format("20060102-150405.000")
+}
+
+// hashBytes returns the hex SHA-256 of data.
+func hashBytes(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// hashFile returns the hex SHA-256 of the file at path.
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// record appends an entry for op. A nil journal records nothing.
+func (j *journal) record(op, source, dest, hash string) error {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.f == nil {
+		f, err := os.OpenFile(j.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, j.perm)
+		if err != nil {
+			return err
+		}
+		j.f = f
+	}
+	line, err := json.Marshal(journalEntry{Run: j.run, Time: time.Now(), Op: op,
+		Source: source, Dest: dest, Hash: hash})
+	if err != nil {
+		return err
+	}
+	if _, err := j.f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return j.f.Sync()
+}
+
+// empty reports whether nothing has been recorded.
+func (j *journal) empty() bool {
+	if j == nil {
+		return true
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.f == nil
+}
+
+// Close closes the journal file, if it was opened.
+func (j *journal) Close() error {
+	if j == nil || j.f == nil {
+		return nil
+	}
+	return j.f.Close()
+}
+
+// mkdirAll makes dir and any parents, journaling each directory it
+// creates, so that undo can remove them again.
+func (p *Processor) mkdirAll(dir string) error {
+	var created []string
+	for d := filepath.Clean(dir); ; d = filepath.Dir(d) {
+		if _, err := os.Lstat(d); err == nil || d == filepath.Dir(d) {
+			break
+		}
+		// The output directory holds the journal, so stays
+		if p.journal == nil || d != filepath.Dir(p.journal.path) {
+			created = append(created, d)
+		}
+	}
+	if err := os.MkdirAll(dir, p.cfg.DirMode); err != nil {
+		return err
+	}
+	for i := len(created) - 1; i >= 0; i-- {
+		if err := p.journal.record(OpMkdir, "", created[i], ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readJournal returns the entries of the journal at path.
+func readJournal(path string) ([]journalEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var entries []journalEntry
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		var e journalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, n, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+// undo reverses what run did according to the journal at path, latest
+// first, writing what it does to w. Files whose content has changed since
+// the run, and directories that are no longer empty, are left alone and
+// reported, and the undo can be tried again once they are dealt with. It
+// returns an error if anything was left. A journal it has to create has
+// permissions perm.
+//
+// A run never writes over a file that was already there, so removing what
+// it wrote loses nothing. Only the PDFs, text files and directories are
+// journaled. The reports in --output, tags.json, words.json, errors.json
+// and duplicates.json, are rewritten by every run and are left as the
+// undone run wrote them.
+func undo(w io.Writer, path, run string, perm os.FileMode) error {
+	entries, err := readJournal(path)
+	if err != nil {
+		return err
+	}
+	var ops []journalEntry
+	for _, e := range entries {
+		if e.Run != run {
+			continue
+		}
+		if e.Op == OpUndone {
+			return fmt.Errorf("run %s was undone at %s", run, e.Time.Format(time.RFC3339))
+		}
+		ops = append(ops, e)
+	}
+	if len(ops) == 0 {
+		return fmt.Errorf("%s: no run %s", path, run)
+	}
+	left := 0
+	for i := len(ops) - 1; i >= 0; i-- {
+		e := ops[i]
+		err := undoEntry(e)
+		switch {
+		case os.IsNotExist(err):
+			fmt.Fprintf(w, "gone %s\n", e.Dest)
+			continue
+		case err != nil:
+			fmt.Fprintf(w, "left %s: %v\n", e.Dest, err)
+			left++
+			continue
+		}
+		if e.Op == OpMove {
+			fmt.Fprintf(w, "moved %s back to %s\n", e.Dest, e.Source)
+			continue
+		}
+		fmt.Fprintf(w, "removed %s\n", e.Dest)
+	}
+	if left > 0 {
+		return fmt.Errorf("undo of run %s left %d files", run, left)
+	}
+	j := &journal{path: path, run: run, perm: perm}
+	defer j.Close()
+	return j.record(OpUndone, "", "", "")
+}
+
+// undoEntry reverses one journaled operation.
+func undoEntry(e journalEntry) error {
+	switch e.Op {
+	case OpMkdir:
+		return os.Remove(e.Dest)
+	case OpSymlink:
+		target, err := os.Readlink(e.Dest)
+		if err != nil {
+			return err
+		}
+		if target != e.Source {
+			return fmt.Errorf("now links to %s", target)
+		}
+		return os.Remove(e.Dest)
+	case OpText, OpCopy, OpHardlink:
+		if err := unchanged(e); err != nil {
+			return err
+		}
+		return os.Remove(e.Dest)
+	case OpMove:
+		if err := unchanged(e); err != nil {
+			return err
+		}
+		if _, err := os.Lstat(e.Source); err == nil {
+			return fmt.Errorf("%s is back", e.Source)
+		}
+		_, err := moveFile(e.Dest, e.Source)
+		return err
+	}
+	return fmt.Errorf("unknown operation %q", e.Op)
+}
+
+// unchanged returns an error if the content at e.Dest is not what the run
+// wrote there.
+func unchanged(e journalEntry) error {
+	hash, err := hashFile(e.Dest)
+	if err != nil {
+		return err
+	}
+	if hash != e.Hash {
+		return fmt.Errorf("changed since the run")
+	}
+	return nil
+}
+
+// undoCommand runs "pdftext undo --run ID" on the journal in output.
+func undoCommand(w io.Writer, output, run string, perm os.FileMode) error {
+	if run == "" {
+		return fmt.Errorf("usage: pdftext undo --run ID")
+	}
+	return undo(w, filepath.Join(output, journalName), run, perm)
+}
@@ -0,0 +1,132 @@
+package pdftext
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Undo removes what a run wrote, but not files changed since
+func TestUndo(t *testing.T) {
+	out, err := ioutil.TempDir("", "undo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(out)
+	in := filepath.Join(out, "in")
+	os.Mkdir(in, 0755)
+	for _, name := range []string{"2019_02_20_10_11_12.pdf", "2019_02_21_10_11_12.pdf"} {
+		ioutil.WriteFile(filepath.Join(in, name), []byte(name), 0644)
+	}
+	cfg := DefaultConfig()
+	cfg.Output = filepath.Join(out, "out")
+	cfg.RunID = "run1"
+	cfg.WriteText = true
+	cfg.FileMode = 0600
+	cfg.Rules = []Rule{{Name: "Fios", Terms: []string{"fios"}, Dest: "Utilities"}}
+	p, err := NewProcessor(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.journal.empty() {
+		t.Error("journal not empty before the run wrote anything")
+	}
+	var written []string
+	for i, name := range []string{"2019_02_20_10_11_12.pdf", "2019_02_21_10_11_12.pdf"} {
+		tag := p.newTag(filepath.Join(in, name))
+		tag.Text = []string{"Fios bill", "Unknown"}[i]
+		tag.processText()
+		if err := tag.file(); err != nil {
+			t.Fatal(err)
+		}
+		written = append(written, tag.NewPDF)
+	}
+	if p.journal.empty() {
+		t.Error("journal empty after the run")
+	}
+	p.Close()
+	// Changed since the run, so undo leaves it
+	ioutil.WriteFile(written[1], []byte("edited"), 0644)
+
+	var buf bytes.Buffer
+	journal := filepath.Join(cfg.Output, journalName)
+	if st, err := os.Stat(journal); err != nil {
+		t.Error(err)
+	} else if st.Mode().Perm() != 0600 {
+		t.Errorf("journal has mode %v, want 0600", st.Mode())
+	}
+	if err := undo(&buf, journal, "run1", 0644); err == nil {
+		t.Error("want an error for the changed file")
+	}
+	if _, err := os.Stat(written[0]); !os.IsNotExist(err) {
+		t.Errorf("%s is still there", written[0])
+	}
+	if _, err := os.Stat(filepath.Join(cfg.Output, "Utilities")); !os.IsNotExist(err) {
+		t.Error("Utilities is still there")
+	}
+	if _, err := os.Stat(written[1]); err != nil {
+		t.Errorf("changed %s was removed", written[1])
+	}
+	if !strings.Contains(buf.String(), "left "+written[1]+": changed since the run") {
+		t.Errorf("got\n%s", buf.String())
+	}
+
+	// Once the changed file is dealt with, the undo can finish
+	os.Remove(written[1])
+	if err := undo(&buf, journal, "run1", 0644); err != nil {
+		t.Error(err)
+	}
+	if err := undo(&buf, journal, "run1", 0644); err == nil || !strings.Contains(err.Error(), "was undone") {
+		t.Errorf("undo twice: got %v", err)
+	}
+	if err := undo(&buf, journal, "run2", 0644); err == nil {
+		t.Error("want an error for an unknown run")
+	}
+}
+
+// A run that finds a file at the new name leaves it, and journals nothing
+// that undo could lose it by
+func TestJournalExisting(t *testing.T) {
+	dir, err := ioutil.TempDir("", "existing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	in, out := filepath.Join(dir, "in"), filepath.Join(dir, "out")
+	os.Mkdir(in, 0755)
+	os.Mkdir(out, 0755)
+	ioutil.WriteFile(filepath.Join(in, "notes.pdf"), []byte("NEW"), 0644)
+	ioutil.WriteFile(filepath.Join(out, "notes.pdf"), []byte("OLD"), 0644)
+	for _, mode := range []string{ModeCopy, ModeMove} {
+		cfg := DefaultConfig()
+		cfg.Output = out
+		cfg.Mode = mode
+		cfg.RunID = mode
+		p, err := NewProcessor(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := p.ProcessFile(context.Background(), filepath.Join(in, "notes.pdf"))
+		p.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Err == nil {
+			t.Errorf("%s: want an error for the file in the way", mode)
+		}
+		if data, _ := ioutil.ReadFile(filepath.Join(out, "notes.pdf")); string(data) != "OLD" {
+			t.Errorf("%s: existing file now has %q", mode, data)
+		}
+		if data, _ := ioutil.ReadFile(filepath.Join(in, "notes.pdf")); string(data) != "NEW" {
+			t.Errorf("%s: original now has %q", mode, data)
+		}
+		var buf bytes.Buffer
+		if err := undo(&buf, filepath.Join(out, journalName), mode, 0644); err == nil {
+			t.Errorf("%s: undo found something to do:\n%s", mode, buf.String())
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package pdftext
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// lintFinding is a problem lintRules found with one rule.
+type lintFinding struct {
+	Rule  int // 1-based position in the table
+	Name  string
+	Error bool
+	Msg   string
+}
+
+func (f lintFinding) String() string {
+	level := "warning"
+	if f.Error {
+		level = "error"
+	}
+	return fmt.Sprintf("rule %d (%s): %s: %s", f.Rule, f.Name, level, f.Msg)
+}
+
+// letterSpaced matches terms like "o r d e" left behind by bad kerning.
+var letterSpaced = regexp.MustCompile(`(^|\s)\S( \S){2,}(\s|$)`)
+
+// lintRule is a rule with its terms compiled, as compileRules would.
+type lintRule struct {
+	Rule
+	implicit bool
+	terms    map[string]*term
+	exclude  map[string]*term
+	maxScore int
+}
+
+// lintRules checks a rule table as written, before compileRules normalizes it.
+// Errors are duplicates, rules another rule always beats, and rules with
+// nothing usable to match; warnings are terms that are probably not what
+// was meant.
+func lintRules(rs []Rule) []lintFinding {
+	var findings []lintFinding
+	report := func(i int, isError bool, format string, args ...interface{}) {
+		findings = append(findings, lintFinding{i + 1, rs[i].Name, isError,
+			fmt.Sprintf(format, args...)})
+	}
+
+	compiled := make([]*lintRule, len(rs))
+	for i, r := range rs {
+		lr := &lintRule{Rule: r, terms: make(map[string]*term), exclude: make(map[string]*term)}
+		compiled[i] = lr
+		terms := r.Terms
+		if len(terms) == 0 {
+			lr.implicit = true
+			terms = strings.Split(r.Name, "-")
+			report(i, false, "no terms, so it matches the pieces of its name %q", terms)
+		}
+		lr.maxScore = 100 * r.Priority
+		usable := false
+		for _, group := range []struct {
+			specs    []string
+			into     map[string]*term
+			required bool
+		}{{terms, lr.terms, true}, {r.Exclude, lr.exclude, false}} {
+			for _, spec := range group.specs {
+				for _, msg := range termArtifacts(spec) {
+					report(i, false, "term %q %s", spec, msg)
+				}
+				t, err := parseTerm(spec)
+				if err != nil {
+					report(i, true, "%v", err)
+					continue
+				}
+				if !lr.implicit && !t.cased && t.rx == nil && strings.ToLower(spec) != spec {
+					report(i, false, "term %q is mixed case but matched lower case; add case: to match it exactly", spec)
+				}
+				group.into[t.spec] = t
+				if group.required {
+					lr.maxScore += t.score()
+					if t.rx != nil || alnumCount(t.text) >= 2 {
+						usable = true
+					}
+				}
+			}
+		}
+		if !usable {
+			report(i, true, "no usable terms")
+		}
+		for spec := range lr.exclude {
+			if lr.terms[spec] != nil {
+				report(i, true, "term %q is both required and excluded, so the rule never matches", spec)
+			}
+		}
+	}
+
+	for i, lr := range compiled {
+		for j, other := range compiled[:i] {
+			if other.Name == lr.Name && sameTerms(other.terms, lr.terms) &&
+				sameTerms(other.exclude, lr.exclude) {
+				report(i, true, "duplicate of rule %d", j+1)
+				break
+			}
+		}
+		for j, other := range compiled {
+			if j == i || other.Name == lr.Name {
+				continue
+			}
+			// other matches whenever lr does, and wins
+			if subset(other.terms, lr.terms) && subset(other.exclude, lr.exclude) &&
+				(other.maxScore > lr.maxScore || other.maxScore == lr.maxScore && j < i) {
+				report(i, true, "never chosen: rule %d (%s) matches whenever it does and scores %d to its %d",
+					j+1, other.Name, other.maxScore, lr.maxScore)
+				break
+			}
+		}
+	}
+	sort.SliceStable(findings, func(i, j int) bool {
+		return findings[i].Rule < findings[j].Rule
+	})
+	return findings
+}
+
+// termArtifacts describes anything in a term that looks like an OCR or
+// editing slip.
+func termArtifacts(spec string) []string {
+	var msgs []string
+	if strings.TrimSpace(spec) != spec {
+		msgs = append(msgs, "has leading or trailing space")
+	}
+	if strings.Contains(spec, "  ") || strings.ContainsAny(spec, "\t\r\n") {
+		msgs = append(msgs, "has stray whitespace")
+	}
+	if letterSpaced.MatchString(spec) {
+		msgs = append(msgs, "looks letter-spaced")
+	}
+	for _, r := range spec {
+		if r > unicode.MaxASCII || !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			msgs = append(msgs, "has unusual characters")
+			break
+		}
+	}
+	return msgs
+}
+
+func alnumCount(s string) int {
+	n := 0
+	for _, r := range s {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			n++
+		}
+	}
+	return n
+}
+
+// subset reports whether every term in a is also in b.
+func subset(a, b map[string]*term) bool {
+	for spec := range a {
+		if b[spec] == nil {
+			return false
+		}
+	}
+	return true
+}
+
+func sameTerms(a, b map[string]*term) bool {
+	return len(a) == len(b) && subset(a, b)
+}
+
+// runLint writes the findings for rs and returns the number of errors.
+func runLint(w io.Writer, rs []Rule) int {
+	errors, warnings := 0, 0
+	for _, f := range lintRules(rs) {
+		fmt.Fprintln(w, f)
+		if f.Error {
+			errors++
+		} else {
+			warnings++
+		}
+	}
+	fmt.Fprintf(w, "%d rules, %d errors, %d warnings\n", len(rs), errors, warnings)
+	return errors
+}
@@ -1,5 +1,8 @@
 package pdftext
 
+// renameKeys is the compiled rule table, used when no --rules file is given.
+// Each entry is a name followed by its terms; a term starting with "!" must
+// not appear in the text.
 var renameKeys = [][]string{
 	[]string{"Friends-Forest", "friends", "forest"},
 	[]string{"Vanguard-1099DIV", "valley forge", "1099-div"},
@@ -21,8 +24,8 @@ var renameKeys = [][]string{
 	[]string{"Family-Eyecare", "family eye care"},
 	[]string{"Nepenthe-Foothills", "foothills property"},
 	[]string{"Nepenthe-Foothills", "liverez"},
-	[]string{"Groton-Insurance", "cambridge mutual", "2493319"},
-	[]string{"Rindge-Insurance", "cambridge mutual", "2526086"},
+	[]string{"Groton-Insurance", "cambridge mutual", "2493319", "!2526086"},
+	[]string{"Rindge-Insurance", "cambridge mutual", "2526086", "!2493319"},
 	[]string{"Rindge-Insurance", "chase", "durand"},
 	[]string{"Jim-LifeInsur", "administrator group"},
 	[]string{"Jim-LifeInsur", "administrator", "ieee"},
@@ -48,12 +51,12 @@ var renameKeys = [][]string{
 	[]string{"Rindge-Door", "overhead door company of concord"},
 	[]string{"Costco-Visa", "costco anywhere visa"},
 	[]string{"Cuisinart", "cuisinart"},
-	[]string{"Nepenthe-Electric", "aps", "energy", "arizona"},
+	[]string{"Nepenthe-Electric", "word:aps", "energy", "arizona"},
 	[]string{"Nepenthe-Electric", "aps.com"},
 	[]string{"Sedona-Sewer-Past-Due", "Roadrunner", "past due", "Sedona"},
 	[]string{"Nepenthe-Water", "arizona", "water"},
-	[]string{"Sedona-Tax", "yavapai", "treasurer"},
-	[]string{"Nepenthe-Tax", "yavapai", "treasurer", "nepenthe"},
+	[]string{"Sedona-Tax", "yavapai", "treasurer", "!nepenthe"},
+	[]string{"Nepenthe-Tax", "yavapai", "treasurer", "nepenthe", "!refund"},
 	[]string{"Nepenthe-Tax-Refund", "yavapai", "treasurer", "refund"},
 	[]string{"Nepenthe-Notice-Value", "yavapai", "assessor", "nepenthe"},
 	[]string{"Buckboard-Notice-Value", "yavapai", "assessor", "hills"},
@@ -200,7 +203,7 @@ var renameKeys = [][]string{
 	[]string{"Groton-Council-Aging", "groton", "council", "aging"},
 	[]string{"AAA", "aaa", "member"},
 	[]string{"IRS", "department", "treasury", "internal", "revenue"},
-	[]string{"CB-CD", "citizens", "bank", "cd", "statement"},
+	[]string{"CB-CD", "citizens", "bank", "word:cd", "statement"},
 	[]string{"Anthem-Summary", "anthem", "health", "care", "summary"},
 	[]string{"Anthem-BC", "anthem", "blue", "cross"},
 	[]string{"Anthem-BCBS", "anthem", "blue", "cross", "shield"},
@@ -342,3 +345,64 @@ var renameKeys = [][]string{
 	//
 	[]string{"Tax-Deductible"},
 }
+
+// ruleCategories files the rules of the compiled table, by name, into
+// categories for tags.json.
+var ruleCategories = map[string][]string{
+	"Medical": {
+		"ActonMedical-Associates", "Anelons-Chiro", "BIDMC-Medical",
+		"Boston-Sports-Shoulder-Center", "Boundaries-Therapy", "CVS-Drugs",
+		"Caremark.com", "Chelmsford-Dermatology", "ChelmsfordPC-Jim",
+		"Community-Chiropractic", "Delta-Dental", "Dental", "Family-Eyecare",
+		"Groton-CVS", "LGH-Cardiology", "Lowell-General", "Medical-DrH",
+		"Medical-Lab", "Medical-Podiatrist", "Medical-Reilly", "Merrimack-Urology",
+		"NVMC", "Nashoba-ED", "Nashoba-Family-Med", "Nashoba-Pathology",
+		"Nashoba-Radiology", "Nashoba-Valley-Medical-Center", "Northeast-Endoscopy",
+		"Quest-Labs", "Refill-RX", "Reilly-ProSports", "Sharon-ENT",
+		"Spine-Orthopaedic-Sport", "Steward-Medical", "Tallman-Eye", "TelDrug",
+		"Walgreens-Littleton", "Westford-Eye-Center",
+	},
+	"Tax": {
+		"1095-C", "1099-HC", "1099-SA", "Auto-Excise", "EFTPS", "Groton-Tax",
+		"IBM-W2", "IRS", "Mass-1099G", "Mass-DOR-Assessment", "MassDOR-Discrepancy",
+		"Massachusetts-Dept-Revenue-Penalty", "Nepenthe-Tax", "Nepenthe-Tax-Refund",
+		"NetApp-W2", "Rindge-Delinq-Tax", "Rindge-RE-Tax", "Rindge-Tax", "Sedona-Tax",
+		"Tax-Deductible", "Vanguard-1099DIV",
+	},
+	"Insurance": {
+		"Anthem-BC", "Anthem-BCBS", "Anthem-Summary", "Auto-Insurance",
+		"Boat-Insurance", "Brown-Insurance", "Brown-Insurance-Electronic-Delivery",
+		"Buckboard-Insurance", "CGLIC", "Groton-Insurance", "HPHC", "Jim-LifeInsur",
+		"Jim-Longterm", "Metlife-Dental", "Murphy-Insurance", "Nepenthe-Insurance",
+		"NetApp-CIGNA", "Rindge-Insurance", "UHC",
+	},
+	"Utilities": {
+		"Buckboard-Sewer", "Consolidated-Communications", "Eastern-Propane",
+		"Eastern-Propane-Oil-Delivery", "Fios", "Groton-Cable", "Groton-Electric",
+		"Groton-Fuel", "Groton-Heating", "Groton-Oil", "Groton-Water",
+		"Nepenthe-Cable", "Nepenthe-Electric", "Nepenthe-Gas", "Nepenthe-Sewer",
+		"Nepenthe-Telephone", "Nepenthe-Water", "Rindge-Cable", "Rindge-Electric",
+		"Rindge-Fuel", "Rindge-Telephone", "Rindge-Water", "Sedona-Sewer-Past-Due",
+	},
+	"Property": {
+		"Asbestos-Removal", "Buckboard-HOA", "Buckboard-Notice-Value",
+		"Delicate-Yard", "Groton-James-Brook-Survey", "Groton-Pest",
+		"Groton-Security", "Groton-Variance", "Groton-Zoning-Board", "JPPestServices",
+		"Monadnock-Security", "Nepenthe-Foothills", "Nepenthe-HOA", "Nepenthe-NHOA",
+		"Nepenthe-Notice-Value", "Overhead-Door-Company", "Pioneer-Title",
+		"Redstone-Properties-Sedona", "Rindge-Alarm", "Rindge-Construction",
+		"Rindge-Door", "Rindge-Generator", "Rindge-HVAC", "Rindge-Pest",
+		"Rindge-Plumbing", "Rindge-Reassessment", "Rindge-Security", "Rindge-Septic",
+		"Rindge-Yard", "Scenic-Landscaping", "Sedona-Buckboard-Sale",
+		"Sedona-Landscaping",
+	},
+	"Donations": {
+		"ACLU", "Abby-Hall-Scholarship", "AmericanForests", "CLAPA-Charitable-Trusts",
+		"Charitable", "CongShalom", "CongShalom-Donation", "Congregation-Beth-Israel",
+		"Epilepsy-Foundation", "Friends-Forest", "Goodwill", "Habitat-Humanity",
+		"In-Memory-David-Larrabee", "Indivisible-Massachusetts", "Loaves-Fishes",
+		"MVJF", "NHLakes", "NRWA", "National-Parks", "Nature-Conservancy",
+		"PJ-Library", "Sustainable-Forestry", "WBUR", "Yellowstone-Association",
+		"Yellowstone-Forever",
+	},
+}
@@ -0,0 +1,333 @@
+package pdftext
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Term prefixes select how a rule term is matched. They may be combined,
+// e.g. "case:word:NetApp".
+//
+//	word:   the term must stand alone, not inside a longer word
+//	case:   the term is matched case-sensitively
+//	re:     the term is a regular expression (case-insensitive unless case:)
+//	fuzzy:  the term may be misspelled, as with --fuzzy (distance 1 if unset)
+//
+// A term without a prefix is a case-insensitive substring, as before, and
+// is matched fuzzily too when --fuzzy is given.
+const (
+	wordPrefix  = "word:"
+	casePrefix  = "case:"
+	regexPrefix = "re:"
+	fuzzyPrefix = "fuzzy:"
+)
+
+// term is a compiled rule term. spec is the term as written in the rule
+// and is the key used in keywords and OutputTag.Tags.
+type term struct {
+	spec     string
+	text     string
+	word     bool
+	cased    bool
+	fuzzy    bool
+	rx       *regexp.Regexp
+	squeezed string // text without white space, for fuzzy matching
+	edits    int    // Config.Fuzzy, the edits any term may need
+}
+
+// parseTerm compiles a rule term. Plain and word: terms are lower cased
+// unless case: is given; regular expressions are left as written.
+func parseTerm(spec string) (*term, error) {
+	t := &term{}
+	rest := spec
+	isRE := false
+	for {
+		switch {
+		case strings.HasPrefix(rest, wordPrefix):
+			t.word = true
+			rest = rest[len(wordPrefix):]
+			continue
+		case strings.HasPrefix(rest, casePrefix):
+			t.cased = true
+			rest = rest[len(casePrefix):]
+			continue
+		case strings.HasPrefix(rest, fuzzyPrefix):
+			t.fuzzy = true
+			rest = rest[len(fuzzyPrefix):]
+			continue
+		case strings.HasPrefix(rest, regexPrefix):
+			isRE = true
+			rest = rest[len(regexPrefix):]
+		}
+		break
+	}
+	if rest == "" {
+		return nil, fmt.Errorf("term %q: nothing to match", spec)
+	}
+	if t.fuzzy && (t.word || t.cased || isRE) {
+		return nil, fmt.Errorf("term %q: fuzzy: cannot be combined with other prefixes", spec)
+	}
+	t.spec = spec
+	t.text = rest
+	if isRE {
+		pattern := rest
+		if t.word {
+			pattern = `\b(?:` + pattern + `)\b`
+		}
+		if !t.cased {
+			pattern = `(?i)` + pattern
+		}
+		rx, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("term %q: %v", spec, err)
+		}
+		t.rx = rx
+	} else if !t.cased {
+		t.spec = strings.ToLower(spec)
+		t.text = strings.ToLower(rest)
+		t.squeezed = squeeze(t.text)
+	}
+	return t, nil
+}
+
+// matchText is a document's text in the forms terms are matched against.
+type matchText struct {
+	text  string
+	lower string
+	// lower without white space, and the offset in text of each of its
+	// bytes; built on first use by a fuzzy term
+	squeezed string
+	offsets  []int
+}
+
+func newMatchText(text string) *matchText {
+	return &matchText{text: text, lower: strings.ToLower(text)}
+}
+
+// squeeze removes the white space from s.
+func squeeze(s string) string {
+	return strings.Join(strings.Fields(s), "")
+}
+
+func (m *matchText) squeeze() {
+	if m.offsets != nil {
+		return
+	}
+	var b strings.Builder
+	m.offsets = make([]int, 0, len(m.lower))
+	for i, r := range m.lower {
+		if !unicode.IsSpace(r) {
+			b.WriteRune(r)
+			for j := 0; j < utf8.RuneLen(r); j++ {
+				m.offsets = append(m.offsets, i+j)
+			}
+		}
+	}
+	m.squeezed = b.String()
+}
+
+// allowEdits lets the term match with up to n edits, as Config.Fuzzy does
+// for every term.
+func (t *term) allowEdits(n int) {
+	t.edits = n
+}
+
+// fuzzyRunes is how long a term must be for each edit it may need. Shorter
+// terms match exactly, or they would turn up inside common words: "bank"
+// in "thank", "form" in "for your".
+const fuzzyRunes = 6
+
+// maxDistance is the number of edits a fuzzy match of the term may need,
+// or -1 if it must match exactly. Short terms get fewer edits so that they
+// do not match everywhere.
+func (t *term) maxDistance() int {
+	// White space is part of what a word: term means
+	if t.squeezed == "" || t.word || !t.fuzzy && t.edits <= 0 {
+		return -1
+	}
+	n := t.edits
+	if n <= 0 {
+		n = 1
+	}
+	limit := utf8.RuneCountInString(t.squeezed) / fuzzyRunes
+	if limit == 0 {
+		return -1
+	}
+	if n > limit {
+		n = limit
+	}
+	return n
+}
+
+// match reports whether the term occurs in m. dist is -1 for an exact
+// match, otherwise the number of edits a fuzzy match needed.
+func (t *term) match(m *matchText) (dist int, ok bool) {
+	start, _, dist := t.find(m)
+	return dist, start >= 0
+}
+
+// find returns the byte offsets in m.text of the first occurrence of the
+// term, or -1, -1. dist is -1 for an exact match; if the term only matched
+// fuzzily it is the number of edits needed and the offsets are approximate.
+// A fuzzy match must start and end at word boundaries of the text, so that
+// "netapp" is not found in "net application".
+func (t *term) find(m *matchText) (start, end, dist int) {
+	if start, end = t.findExact(m); start >= 0 {
+		return start, end, -1
+	}
+	k := t.maxDistance()
+	if k < 0 {
+		return -1, -1, -1
+	}
+	m.squeeze()
+	// The start of a match is only known to within its edits
+	bounded := func(last, dist int) bool {
+		first := last + 1 - len(t.squeezed)
+		for i := maxInt(first-dist, 0); i <= first+dist && i <= last; i++ {
+			if isBoundary(m.lower, m.offsets[i], m.offsets[last]+1) {
+				return true
+			}
+		}
+		return false
+	}
+	last, dist := approxIndex(m.squeezed, t.squeezed, k, bounded)
+	if last < 0 {
+		return -1, -1, -1
+	}
+	first := last + 1 - len(t.squeezed)
+	if first < 0 {
+		first = 0
+	}
+	return m.offsets[first], m.offsets[last] + 1, dist
+}
+
+func (t *term) findExact(m *matchText) (int, int) {
+	if t.rx != nil {
+		if loc := t.rx.FindStringIndex(m.text); loc != nil {
+			return loc[0], loc[1]
+		}
+		return -1, -1
+	}
+	s := m.lower
+	if t.cased {
+		s = m.text
+	}
+	if !t.word {
+		if i := strings.Index(s, t.text); i >= 0 {
+			return i, i + len(t.text)
+		}
+		return -1, -1
+	}
+	for off := 0; off < len(s); {
+		i := strings.Index(s[off:], t.text)
+		if i < 0 {
+			break
+		}
+		i += off
+		if isBoundary(s, i, i+len(t.text)) {
+			return i, i + len(t.text)
+		}
+		_, size := utf8.DecodeRuneInString(s[i:])
+		off = i + size
+	}
+	return -1, -1
+}
+
+// approxIndex finds the substring of text closest to pattern by edit
+// distance, returning the offset of its last byte and the distance, or -1
+// if every substring needs more than k edits. If accept is not nil, only
+// substrings it accepts, by last byte and distance, are considered.
+// Patterns up to 64 bytes use Myers' bit-parallel algorithm; longer ones
+// the plain dynamic program.
+func approxIndex(text, pattern string, k int, accept func(last, dist int) bool) (int, int) {
+	m := len(pattern)
+	best, bestDist := -1, k+1
+	if m == 0 {
+		return -1, 0
+	}
+	if m > 64 {
+		// col[i] is the distance of pattern[:i] to the best substring
+		// ending at the current byte of text
+		col := make([]int, m+1)
+		for i := range col {
+			col[i] = i
+		}
+		for j := 0; j < len(text); j++ {
+			diag := col[0]
+			for i := 1; i <= m; i++ {
+				cost := 1
+				if pattern[i-1] == text[j] {
+					cost = 0
+				}
+				next := minInt(diag+cost, minInt(col[i]+1, col[i-1]+1))
+				diag, col[i] = col[i], next
+			}
+			if col[m] < bestDist && (accept == nil || accept(j, col[m])) {
+				best, bestDist = j, col[m]
+			}
+		}
+		return best, bestDist
+	}
+	var peq [256]uint64
+	for i := 0; i < m; i++ {
+		peq[pattern[i]] |= 1 << uint(i)
+	}
+	var pv, mv uint64 = ^uint64(0), 0
+	high := uint64(1) << uint(m-1)
+	score := m
+	for j := 0; j < len(text); j++ {
+		eq := peq[text[j]]
+		xv := eq | mv
+		xh := (((eq & pv) + pv) ^ pv) | eq
+		ph := mv | ^(xh | pv)
+		mh := pv & xh
+		if ph&high != 0 {
+			score++
+		} else if mh&high != 0 {
+			score--
+		}
+		ph <<= 1
+		mh <<= 1
+		pv = mh | ^(xv | ph)
+		mv = ph & xv
+		if score < bestDist && (accept == nil || accept(j, score)) {
+			best, bestDist = j, score
+		}
+	}
+	return best, bestDist
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// isBoundary reports whether s[start:end] is not preceded or followed by a
+// letter or digit.
+func isBoundary(s string, start, end int) bool {
+	if start > 0 {
+		r, _ := utf8.DecodeLastRuneInString(s[:start])
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return false
+		}
+	}
+	if end < len(s) {
+		r, _ := utf8.DecodeRuneInString(s[end:])
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,110 @@
+package pdftext
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTermMatch(t *testing.T) {
+	tests := []struct {
+		spec, text string
+		want       bool
+	}{
+		{"aps", "perhaps not", true},
+		{"word:aps", "perhaps not", false},
+		{"word:aps", "Pay APS today", true},
+		{"word:aps.com", "visit aps.com.", true},
+		{"word:cd", "abcd\ncd statement", true},
+		{"case:NetApp", "netapp inc", false},
+		{"case:NetApp", "NetApp Inc", true},
+		{`re:policy\s+25\d+`, "Policy 2526086", true},
+		{`case:re:policy\s+25\d+`, "Policy 2526086", false},
+		{`word:re:c[a-z]`, "abcd", false},
+	}
+	for _, test := range tests {
+		term, err := parseTerm(test.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, got := term.match(newMatchText(test.text)); got != test.want {
+			t.Errorf("%q in %q: got %v, want %v", test.spec, test.text, got, test.want)
+		}
+	}
+	if _, err := parseTerm("re:(unclosed"); err == nil {
+		t.Error("want an error for a bad regular expression")
+	}
+}
+
+func TestFuzzyMatch(t *testing.T) {
+	tests := []struct {
+		spec, text string
+		dist       int
+		want       bool
+	}{
+		{"scenic", "Scenic Landscaping", -1, true},
+		{"scenic", "Sc enic Landscaping", 0, true},
+		{"scenic", "SCEN1C landscaping", 1, true},
+		{"scenic", "scan landscaping", 0, false},
+		{"sc enic", "scenic", 0, true},
+		{"wiisonsservicegroton", "Wilsons Service Groton", 1, true},
+		{"cd", "c d statement", 0, false},
+		{"cd", "ce statement", 0, false},
+		{"word:scenic", "scen1c", 0, false},
+		// Short terms, and matches inside or across words, are not fuzzy
+		{"bank", "Thank you for your various purchases", 0, false},
+		{"form", "Thank you for your various purchases", 0, false},
+		{"nhoa", "whoa there", 0, false},
+		{"netapp", "net application", 0, false},
+		{"scenic", "picturescenlc views", 0, false},
+		{"scenic", "sceniic views", 1, true},
+		{"landscaping", "landscapeing inc", 1, true},
+	}
+	for _, test := range tests {
+		term, err := parseTerm(test.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		term.allowEdits(1)
+		dist, got := term.match(newMatchText(test.text))
+		if got != test.want || got && dist != test.dist {
+			t.Errorf("%q in %q: got %v at %d, want %v at %d", test.spec, test.text, got, dist, test.want, test.dist)
+		}
+	}
+}
+
+// Check both edit distance searches against the obvious definition
+func TestApproxIndex(t *testing.T) {
+	distance := func(a, b string) int {
+		prev := make([]int, len(b)+1)
+		for j := range prev {
+			prev[j] = j
+		}
+		for i := 1; i <= len(a); i++ {
+			cur := make([]int, len(b)+1)
+			cur[0] = i
+			for j := 1; j <= len(b); j++ {
+				cost := 1
+				if a[i-1] == b[j-1] {
+					cost = 0
+				}
+				cur[j] = minInt(prev[j-1]+cost, minInt(prev[j]+1, cur[j-1]+1))
+			}
+			prev = cur
+		}
+		return prev[len(b)]
+	}
+	text := "the quick brown fox jumps over the lazy dog near the river bank"
+	patterns := []string{"quack", "lazy dgo", "rivr bnk", "zzzz", strings.Repeat("the lazy dog ", 6)}
+	for _, p := range patterns {
+		want := len(p)
+		for i := 0; i <= len(text); i++ {
+			for j := i; j <= len(text); j++ {
+				want = minInt(want, distance(text[i:j], p))
+			}
+		}
+		_, got := approxIndex(text, p, len(p), nil)
+		if got != want {
+			t.Errorf("%q: got distance %d, want %d", p, got, want)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package pdftext
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"syscall"
+)
+
+// How a PDF is put at its new name, set by --mode. Each is also the
+// journal operation recording it.
+const (
+	ModeCopy     = "copy"     // Copy the original
+	ModeSymlink  = "symlink"  // Link to the original by name
+	ModeHardlink = "hardlink" // Link to the original's content
+	ModeMove     = "move"     // Move the original
+)
+
+func checkMode(mode string) error {
+	switch mode {
+	case ModeCopy, ModeSymlink, ModeHardlink, ModeMove:
+		return nil
+	}
+	return fmt.Errorf("unknown mode %q, want copy, symlink, hardlink or move", mode)
+}
+
+// placePDF puts the PDF at src at dest as mode says, returning the hash of
+// the content at dest, or "" for a symlink. A copy is written atomically
+// with permissions perm. Nothing already at dest is replaced. On failure
+// the original is left where it was, and a *FileError is returned.
+func placePDF(mode, src, dest string, perm os.FileMode) (string, error) {
+	switch mode {
+	case ModeSymlink:
+		if err := os.Symlink(src, dest); err != nil {
+			return "", fileError(KindSymlink, StageCopy, dest, err)
+		}
+		return "", nil
+	case ModeHardlink:
+		if err := os.Link(src, dest); err != nil {
+			return "", fileError(KindLink, StageCopy, dest, err)
+		}
+		hash, err := hashFile(dest)
+		if err != nil {
+			return "", fileError(KindOpen, StageCopy, dest, err)
+		}
+		return hash, nil
+	case ModeMove:
+		hash, err := moveFile(src, dest)
+		if err != nil {
+			return "", fileError(KindMove, StageCopy, dest, err)
+		}
+		return hash, nil
+	}
+	bytes, err := ioutil.ReadFile(src)
+	if err != nil {
+		return "", fileError(KindOpen, StageCopy, src, err)
+	}
+	err = writeNewFileAtomic(dest, bytes, perm)
+	if err != nil {
+		return "", fileError(KindWrite, StageCopy, dest, err)
+	}
+	return hashBytes(bytes), nil
+}
+
+// moveFile moves src to dest, which must not exist: it links src at dest
+// and removes src, or between file systems copies it with the same
+// permissions, checks the copy and removes src. It returns the hash of the
+// content. If it fails, src is as it was, and dest is not left behind or
+// replaced.
+func moveFile(src, dest string) (string, error) {
+	err := os.Link(src, dest)
+	var linkErr *os.LinkError
+	switch {
+	case err == nil:
+		if err := os.Remove(src); err != nil {
+			os.Remove(dest)
+			return "", err
+		}
+		return hashFile(dest)
+	case os.IsExist(err):
+		return "", err
+	case !errors.As(err, &linkErr) || linkErr.Err != syscall.EXDEV:
+		// A file system without hard links can still rename
+		if _, err := os.Lstat(dest); err == nil {
+			return "", &os.LinkError{Op: "move", Old: src, New: dest, Err: os.ErrExist}
+		}
+		if err := os.Rename(src, dest); err != nil {
+			return "", err
+		}
+		return hashFile(dest)
+	}
+	st, err := os.Stat(src)
+	if err != nil {
+		return "", err
+	}
+	bytes, err := ioutil.ReadFile(src)
+	if err != nil {
+		return "", err
+	}
+	hash := hashBytes(bytes)
+	if err := writeNewFileAtomic(dest, bytes, st.Mode().Perm()); err != nil {
+		return "", err
+	}
+	if copied, err := hashFile(dest); err != nil || copied != hash {
+		os.Remove(dest)
+		if err == nil {
+			err = fmt.Errorf("copy of %s does not match it", src)
+		}
+		return "", err
+	}
+	if err := os.Remove(src); err != nil {
+		os.Remove(dest)
+		return "", err
+	}
+	return hash, nil
+}
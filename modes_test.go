@@ -0,0 +1,106 @@
+package pdftext
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPlacePDF(t *testing.T) {
+	dir, err := ioutil.TempDir("", "modes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, mode := range []string{ModeCopy, ModeSymlink, ModeHardlink, ModeMove} {
+		src := filepath.Join(dir, mode+".pdf")
+		dest := filepath.Join(dir, mode+"-new.pdf")
+		ioutil.WriteFile(src, []byte(mode), 0644)
+		hash, err := placePDF(mode, src, dest, 0600)
+		if err != nil {
+			t.Fatal(mode, err)
+		}
+		if data, err := ioutil.ReadFile(dest); err != nil || string(data) != mode {
+			t.Errorf("%s: new PDF has %q, %v", mode, data, err)
+		}
+		if st, err := os.Stat(dest); mode == ModeCopy && (err != nil || st.Mode().Perm() != 0600) {
+			t.Errorf("copy has mode %v, %v", st.Mode(), err)
+		}
+		if want := hashBytes([]byte(mode)); mode != ModeSymlink && hash != want {
+			t.Errorf("%s: hash %s, want %s", mode, hash, want)
+		}
+		_, err = os.Stat(src)
+		if kept := err == nil; kept != (mode != ModeMove) {
+			t.Errorf("%s: original kept %v", mode, kept)
+		}
+	}
+
+	// A failed move leaves the original
+	src := filepath.Join(dir, "orig.pdf")
+	ioutil.WriteFile(src, []byte("orig"), 0644)
+	if _, err := placePDF(ModeMove, src, filepath.Join(dir, "missing", "new.pdf"), 0600); err == nil {
+		t.Error("want an error moving into a missing directory")
+	} else if fe, ok := err.(*FileError); !ok || fe.Kind != KindMove {
+		t.Errorf("got %v", err)
+	}
+	if _, err := os.Stat(src); err != nil {
+		t.Error("failed move lost the original")
+	}
+
+	// Nothing already at the new name is replaced, whatever the mode
+	for _, mode := range []string{ModeCopy, ModeSymlink, ModeHardlink, ModeMove} {
+		dest := filepath.Join(dir, "taken.pdf")
+		ioutil.WriteFile(dest, []byte("old"), 0644)
+		if _, err := placePDF(mode, src, dest, 0600); err == nil {
+			t.Errorf("%s: want an error placing over an existing file", mode)
+		}
+		if data, _ := ioutil.ReadFile(dest); string(data) != "old" {
+			t.Errorf("%s: existing file now has %q", mode, data)
+		}
+		if data, _ := ioutil.ReadFile(src); string(data) != "orig" {
+			t.Errorf("%s: original now has %q", mode, data)
+		}
+	}
+	if checkMode("rename") == nil {
+		t.Error("want an error for an unknown mode")
+	}
+}
+
+// Undoing a move puts the original back
+func TestUndoMove(t *testing.T) {
+	dir, err := ioutil.TempDir("", "undomove")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "2019_02_20_10_11_12.pdf")
+	ioutil.WriteFile(src, []byte("pdf"), 0644)
+	cfg := DefaultConfig()
+	cfg.Output = filepath.Join(dir, "out")
+	cfg.Mode = ModeMove
+	cfg.RunID = "move"
+	cfg.Rules = []Rule{{Name: "Fios", Terms: []string{"fios"}}}
+	p, err := NewProcessor(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tag := p.newTag(src)
+	tag.Text = "Fios bill"
+	tag.processText()
+	if err := tag.file(); err != nil {
+		t.Fatal(err)
+	}
+	p.Close()
+	if tag.Mode != ModeMove || filepath.Base(tag.NewPDF) != "Fios.pdf" {
+		t.Errorf("got %s by %s", tag.NewPDF, tag.Mode)
+	}
+	var buf bytes.Buffer
+	if err := undo(&buf, filepath.Join(cfg.Output, journalName), "move", 0644); err != nil {
+		t.Fatal(err, buf.String())
+	}
+	if data, err := ioutil.ReadFile(src); err != nil || string(data) != "pdf" {
+		t.Errorf("original not restored: %q, %v", data, err)
+	}
+}
@@ -0,0 +1,162 @@
+package pdftext
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"math"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Near duplicates are found by MinHash: each text is cut into shingles of
+// shingleWords consecutive words, and its signature is the least hash of
+// its shingles under each of signatureSize hash functions. The fraction of
+// two signatures that agree estimates how many shingles the texts share.
+// Signatures are split into bands of bandRows; only texts agreeing on a
+// whole band are compared, which finds nearly all pairs at the high
+// thresholds that matter here.
+const (
+	shingleWords  = 3
+	signatureSize = 128
+	bandRows      = 4
+)
+
+// defaultSimilarity is how alike two texts must be to be near duplicates
+const defaultSimilarity = 0.9
+
+// minhash returns the signature of the words of a text, in order, or nil if
+// there are none.
+func minhash(words []string) []uint64 {
+	if len(words) == 0 {
+		return nil
+	}
+	sig := make([]uint64, signatureSize)
+	for i := range sig {
+		sig[i] = math.MaxUint64
+	}
+	n := len(words) - shingleWords + 1
+	if n < 1 {
+		// Too short for a shingle, so the whole text is one
+		n = 1
+	}
+	for s := 0; s < n; s++ {
+		end := s + shingleWords
+		if end > len(words) {
+			end = len(words)
+		}
+		h := fnv.New64a()
+		h.Write([]byte(strings.Join(words[s:end], " ")))
+		x := h.Sum64()
+		for i := range sig {
+			if v := mix(x + uint64(i)*0x9e3779b97f4a7c15); v < sig[i] {
+				sig[i] = v
+			}
+		}
+	}
+	return sig
+}
+
+// mix is the splitmix64 finalizer, which makes each seed of minhash a
+// different hash function.
+func mix(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	return x ^ x>>31
+}
+
+// similarity estimates the share of shingles the texts signed a and b
+// have in common.
+func similarity(a, b []uint64) float64 {
+	same := 0
+	for i := range a {
+		if a[i] == b[i] {
+			same++
+		}
+	}
+	return float64(same) / float64(len(a))
+}
+
+// nearDuplicate is a PDF in a group of near duplicates.
+type nearDuplicate struct {
+	OriginalPDF string
+	NewPDF      string
+	Similarity  float64 // To the first PDF of the group
+}
+
+// nearDuplicates groups the tags whose texts are at least threshold alike,
+// directly or through others in the group. Groups are in order of their
+// first PDF, and PDFs in a group by original name.
+func nearDuplicates(tags []*OutputTag, threshold float64) [][]nearDuplicate {
+	tags = append([]*OutputTag(nil), tags...)
+	sort.Slice(tags, func(i, j int) bool { return tags[i].OriginalPDF < tags[j].OriginalPDF })
+	// Each tag's group is found by following parent to its root
+	parent := make([]int, len(tags))
+	for i := range parent {
+		parent[i] = i
+	}
+	var root func(i int) int
+	root = func(i int) int {
+		if parent[i] != i {
+			parent[i] = root(parent[i])
+		}
+		return parent[i]
+	}
+	for band := 0; band < signatureSize; band += bandRows {
+		buckets := make(map[[bandRows]uint64][]int)
+		for i, tag := range tags {
+			if tag.signature == nil {
+				continue
+			}
+			var key [bandRows]uint64
+			copy(key[:], tag.signature[band:])
+			buckets[key] = append(buckets[key], i)
+		}
+		for _, b := range buckets {
+			for _, i := range b[1:] {
+				ri, rj := root(i), root(b[0])
+				if ri != rj && similarity(tags[i].signature, tags[b[0]].signature) >= threshold {
+					// The lower root keeps the group in order
+					if ri < rj {
+						ri, rj = rj, ri
+					}
+					parent[ri] = rj
+				}
+			}
+		}
+	}
+	members := make(map[int][]int)
+	for i := range tags {
+		if r := root(i); r != i {
+			members[r] = append(members[r], i)
+		}
+	}
+	var groups [][]nearDuplicate
+	for i, tag := range tags {
+		if members[i] == nil {
+			continue
+		}
+		group := []nearDuplicate{{tag.OriginalPDF, tag.NewPDF, 1}}
+		for _, j := range members[i] {
+			group = append(group, nearDuplicate{tags[j].OriginalPDF, tags[j].NewPDF,
+				similarity(tag.signature, tags[j].signature)})
+		}
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+// writeDuplicates writes the groups of near duplicates to path as JSON,
+// with permissions perm.
+func writeDuplicates(path string, groups [][]nearDuplicate, perm os.FileMode) error {
+	if groups == nil {
+		groups = [][]nearDuplicate{}
+	}
+	bytes, err := json.MarshalIndent(groups, " ", "")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, bytes, perm)
+}
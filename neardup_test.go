@@ -0,0 +1,61 @@
+package pdftext
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNearDuplicates(t *testing.T) {
+	p := newTestProcessor(t, []Rule{})
+	var body []string
+	for i := 0; i < 100; i++ {
+		body = append(body, fmt.Sprintf("charge%d %d.00", i, i*7))
+	}
+	statement := strings.Join(body, " ")
+	texts := map[string]string{
+		"a.pdf": "Generated 2019-02-20 10:11:12 " + statement,
+		"b.pdf": "Generated 2019-03-01 08:00:00 " + statement,
+		"c.pdf": "Something else entirely, " + strings.Repeat("with other words ", 20),
+		"d.pdf": statement + " Generated 2019-03-02 09:30:00",
+	}
+	var tags []*OutputTag
+	for _, name := range []string{"d.pdf", "c.pdf", "b.pdf", "a.pdf"} {
+		tag := p.newTag(name)
+		tag.Text = texts[name]
+		tag.processText()
+		tags = append(tags, tag)
+	}
+	groups := nearDuplicates(tags, 0.9)
+	if len(groups) != 1 || len(groups[0]) != 3 {
+		t.Fatalf("got groups %v", groups)
+	}
+	for i, want := range []string{"a.pdf", "b.pdf", "d.pdf"} {
+		d := groups[0][i]
+		if d.OriginalPDF != want || d.Similarity < 0.9 {
+			t.Errorf("member %d is %s, %v alike", i, d.OriginalPDF, d.Similarity)
+		}
+	}
+	if s := similarity(tags[1].signature, tags[3].signature); s > 0.5 {
+		t.Errorf("different texts are %v alike", s)
+	}
+	if minhash(nil) != nil || len(minhash([]string{"one"})) != signatureSize {
+		t.Error("want no signature for no words, and one for a single word")
+	}
+
+	out, err := ioutil.TempDir("", "neardup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(out)
+	path := filepath.Join(out, "duplicates.json")
+	if err := writeDuplicates(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadFile(path); string(data) != "[]" {
+		t.Errorf("duplicates.json has %s", data)
+	}
+}
@@ -1,11 +1,37 @@
 package pdftext
 
-import "testing"
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
 
 func Xxx() {
 }
 
 // Measure the time to create a 256 bit random
 func TestRun(t *testing.T) {
-	Run()
+	out, err := ioutil.TempDir("", "run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(out)
+	cfg := DefaultConfig()
+	cfg.Output = out
+	if err := Run(cfg, Command{Dir: "."}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Name the sample documents in testdata/rules with the compiled rules
+func TestRulesSamples(t *testing.T) {
+	cases, err := newTestProcessor(t, nil).testRules(ioutil.Discard, "testdata/rules", "testdata/rules/expect.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range cases {
+		if c.Got != c.Want {
+			t.Errorf("%s: got %s, want %s", c.File, c.Got, c.Want)
+		}
+	}
 }
@@ -1,128 +1,135 @@
 package pdftext
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"log"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
-	"runtime"
+	"runtime/debug"
 	"sort"
 	"strings"
-	"sync"
 	"time"
 	"unicode"
 	"unicode/utf8"
 
-	flag "github.com/spf13/pflag"
 	"rcs.io/pdf"
 )
 
-var (
-	//	debug = flag.BoolP("debug", "d", false, "Print only uncategorized lines")
-	dir           = flag.StringP("dir", "d", ".", "Descend into this directory")
-	output        = flag.StringP("output", "o", "", "Place PDFs and text in this directory")
-	files         = flag.StringArrayP("files", "f", []string{}, "Access these specific files")
-	writetext     = flag.BoolP("text", "t", false, "Print recovered text")
-	renameNewOnly = flag.BoolP("renamenew", "r", true, "Rename only new timestamp filenames")
-	debug         = flag.BoolP("debug", "b", false, "Debug")
-	symlink       = flag.BoolP("symlink", "s", false, "Create symlink to original PDF")
-	lines         = flag.IntSliceP("line", "l", []int{}, "Lines to show in debug")
-	tagcvtonly    = flag.BoolP("tagonly", "n", true, "Write tags only for unmatched PDFs")
-	threads       = flag.IntP("threads", "c", 0, "Count of concurrent threads for processing files")
-
-	linemap      = make(map[int]bool)
-	newFileNames = make(map[string]bool)
-)
-
-// Parse the options. Use of the 'files' flag overrides the 'dir' scan
-// For files, range of the file list and invoke processFile
-var preexistingFiles []string
 var numericName = regexp.MustCompile(`\d\d\d\d(_\d\d){5}.pdf$`)
 
-var start time.Time
-
-func elapsed() time.Duration {
-	return time.Now().Sub(start)
+// Command is what the pdftext command is asked to do with a Config: a
+// subcommand, the files to process, or else the directory to scan.
+type Command struct {
+	Args  []string // A subcommand, explain, rules or undo, and its arguments
+	Files []string // Process just these files
+	Dir   string   // Otherwise process every PDF under this directory
+	Plan  string   // With DryRun, also write the planned renames to this JSON file
+	Undo  string   // The run for undo to reverse
 }
 
-// Run does everything
-func Run() {
-	start = time.Now()
-	flag.Parse()
-	for _, i := range *lines {
-		linemap[i] = true
+// Run does everything the pdftext command does: cmd with cfg. Unless
+// cfg.RunID is set, what it writes is journaled under a new run ID. Files
+// that fail are listed at the end and make Run return an error, but do not
+// stop the others being processed.
+func Run(cfg Config, cmd Command) error {
+	if cfg.RunID == "" {
+		cfg.RunID = newRunID(time.Now())
+	}
+	p, err := NewProcessor(cfg)
+	if err != nil {
+		return err
+	}
+	defer p.Close()
+	if args := cmd.Args; len(args) > 0 {
+		switch args[0] {
+		case "explain":
+			for _, file := range args[1:] {
+				if err := p.explain(os.Stdout, file); err != nil {
+					return err
+				}
+			}
+		case "rules":
+			return p.rulesCommand(os.Stdout, args[1:])
+		case "undo":
+			return undoCommand(os.Stdout, cfg.Output, cmd.Undo, p.cfg.FileMode)
+		default:
+			return fmt.Errorf("unknown command %s", args[0])
+		}
+		return nil
 	}
-	if len(*files) > 0 {
-		for _, file := range *files {
+	failed := make(map[string]error)
+	total := 0
+	if len(cmd.Files) > 0 {
+		for _, file := range cmd.Files {
+			total++
 			// Ignore the returned tag info here.
-			alltext := processFile(file)
+			ctx, cancel := p.fileContext(context.Background())
+			alltext, _, pageErrs, err := p.processFile(ctx, file)
+			cancel()
+			for _, err := range pageErrs {
+				fmt.Println(err)
+			}
+			if err != nil {
+				failed[file] = err
+				continue
+			}
 			findFirstDate(alltext)
-			if *writetext {
+			if cfg.WriteText {
 				fmt.Println(file)
-				if *debug != true {
+				if cfg.Debug != true {
 					fmt.Println(alltext)
 				}
 
 			}
 		}
 	} else {
-		preexistingFiles, err := filepath.Glob("*pdf")
-		if err != nil {
-			log.Fatalln(err)
-		}
-		for _, f := range preexistingFiles {
-			newFileNames[strings.Replace(filepath.Base(f), ".pdf", "", 1)] = true
-		}
 		var alltags = make(map[string]*OutputTag)
-		var wg sync.WaitGroup
-		var mtx sync.Mutex
-		if *threads == 0 {
-			*threads = (runtime.NumCPU() + 1) / 2
-		}
-		tagChan := make(chan OutputTag, *threads)
-		for i := 0; i < *threads; i++ {
-			tagChan <- OutputTag{}
-		}
-		filepath.Walk(*dir, func(path string, info os.FileInfo, err error) error {
-			var reterr error
-			if info.IsDir() == true {
-				return reterr
+		var allWords = make(map[string]int)
+		results, err := p.ProcessDir(context.Background(), cmd.Dir)
+		if err != nil {
+			return err
+		}
+		report := make(map[string][]error)
+		var plan []planEntry
+		var texts []*OutputTag
+		for result := range results {
+			total++
+			report[result.OriginalPDF] = result.pageErrs
+			if result.Err != nil {
+				failed[result.OriginalPDF] = result.Err
+				report[result.OriginalPDF] = append(report[result.OriginalPDF], result.Err)
+				continue
 			}
-			if !strings.HasSuffix(path, ".pdf") {
-				return reterr
+			result.Extract(alltags, allWords)
+			plan = append(plan, planEntry{result.OriginalPDF, result.NewPDF, result.Rule})
+			if result.signature != nil {
+				texts = append(texts, result.OutputTag)
 			}
-			oldTag := <-tagChan
-			var tag = OutputTag{
-				OriginalPDF: path,
-				Output:      *output,
-				WG:          &wg,
-				tagChan:     tagChan,
-				Mutex:       &mtx,
+		}
+		if cfg.Progress != nil {
+			cfg.Progress.Finish()
+		}
+		if cfg.DryRun {
+			if err := writePlan(os.Stdout, cmd.Plan, plan, p.cfg.FileMode); err != nil {
+				return err
 			}
-			wg.Add(1)
-			go tag.Process()
-			oldTag.Extract(alltags)
-
-			return nil
-		})
-		wg.Wait()
-		for i := 0; i < *threads; i++ {
-			oldTag := <-tagChan
-			oldTag.Extract(alltags)
+			return summarize(os.Stdout, total, failed)
 		}
 
 		fmt.Println("Writing tags.json")
 		// Create the JSON tag file
 		bytes, err := json.MarshalIndent(alltags, " ", "")
 		if err != nil {
-			log.Fatalln(err)
+			return err
 		}
-		err = ioutil.WriteFile(filepath.Join(*output, "tags.json"), bytes, os.ModePerm)
+		err = writeFileAtomic(filepath.Join(cfg.Output, "tags.json"), bytes, p.cfg.FileMode)
 		if err != nil {
-			log.Fatalln(err)
+			return err
 		}
 		// Create the word count file
 		for k, v := range allWords {
@@ -132,19 +139,54 @@ func Run() {
 		}
 		bytes, err = json.MarshalIndent(allWords, " ", "")
 		if err != nil {
-			log.Fatalln(err)
+			return err
+		}
+		err = writeFileAtomic(filepath.Join(cfg.Output, "words.json"), bytes, p.cfg.FileMode)
+		if err != nil {
+			return err
+		}
+		if cfg.Similarity > 0 {
+			groups := nearDuplicates(texts, cfg.Similarity)
+			err = writeDuplicates(filepath.Join(cfg.Output, "duplicates.json"), groups, p.cfg.FileMode)
+			if err != nil {
+				return err
+			}
 		}
-		err = ioutil.WriteFile(filepath.Join(*output, "words.json"), bytes, os.ModePerm)
+		// Record the failures for a rerun with --files
+		err = writeErrors(filepath.Join(cfg.Output, "errors.json"), report, p.cfg.FileMode)
 		if err != nil {
-			log.Fatalln(err)
+			return err
+		}
+		if !p.journal.empty() {
+			fmt.Println("Run", cfg.RunID, "can be reversed with: pdftext undo --run", cfg.RunID)
 		}
 
 	}
 
+	return summarize(os.Stdout, total, failed)
 }
 
-// Extract blah
-func (tag *OutputTag) Extract(alltags map[string]*OutputTag) {
+// Return the names taken in dir, starting with the PDFs already there.
+// Call with p.mu held.
+func (p *Processor) takenNames(dir string) map[string]bool {
+	names := p.newFileNames[dir]
+	if names == nil {
+		names = make(map[string]bool)
+		// A directory that does not exist yet has no names taken
+		infos, _ := ioutil.ReadDir(dir)
+		for _, info := range infos {
+			if strings.HasSuffix(info.Name(), ".pdf") {
+				names[strings.TrimSuffix(info.Name(), ".pdf")] = true
+			}
+		}
+		p.newFileNames[dir] = names
+	}
+	return names
+}
+
+// Extract adds the tag to alltags, and the words of an unrenamed file to
+// allWords
+func (tag *OutputTag) Extract(alltags map[string]*OutputTag, allWords map[string]int) {
 	if tag.OriginalPDF != "" {
 		if tag.AddToAllTags {
 			alltags[filepath.Base(tag.OriginalPDF)] = tag
@@ -161,70 +203,140 @@ func (tag *OutputTag) Extract(alltags map[string]*OutputTag) {
 	}
 }
 
-// Process a tag
-func (tag *OutputTag) Process() {
-	defer func() {
-		tag.tagChan <- *tag
-		tag.WG.Done()
-	}()
+// Process a tag, returning a *FileError if the PDF cannot be read or
+// written, or ctx is done first. A PDF whose text cannot be extracted is
+// still filed, as notext-NAME, and the error says why. Callers outside
+// the package use Processor.ProcessFile.
+func (tag *OutputTag) process(ctx context.Context) error {
+	err := tag.extract(ctx)
+	if err != nil && !noText(err) {
+		return err
+	}
+	if fileErr := tag.file(); fileErr != nil {
+		return fileErr
+	}
+	return err
+}
+
+// Is the PDF to be renamed? Only strict timestamp names are, unless
+// RenameNewOnly is off.
+func (tag *OutputTag) renames() bool {
+	return numericName.MatchString(tag.OriginalPDF) || !tag.proc.cfg.RenameNewOnly
+}
+
+// Recover the text of a PDF that is to be renamed and match it against
+// the rules. This is the slow part, which ProcessDir runs in parallel.
+func (tag *OutputTag) extract(ctx context.Context) error {
+	hash, err := hashFile(tag.OriginalPDF)
+	if err != nil {
+		return fileError(KindOpen, StageOpen, tag.OriginalPDF, err)
+	}
+	tag.SHA256 = hash
+	if !tag.renames() {
+		return nil
+	}
+	return tag.processFileAndRename(ctx)
+}
+
+// Name an extracted tag and write the PDF, and its text, to the output
+func (tag *OutputTag) file() error {
 	var err error
-	tag.Tags = make(map[string]bool)
+	p := tag.proc
+	cfg := &p.cfg
 	path := tag.OriginalPDF
-	base := filepath.Base(path)
-	textf := filepath.Join(tag.Output,
-		strings.Replace(base, ".pdf", ".txt", 1))
-	tag.TextFileName = textf
 	// Is this a strict timestamp name?
 	isNumericName := numericName.MatchString(path)
-	pdf := filepath.Join(*output, base)
-	tag.NewPDF = pdf
-	if isNumericName || !*renameNewOnly {
-		tag.processFileAndRename()
-		tag.renameBase()
+	if tag.SHA256 != "" {
+		tag.DuplicateOf = tag.duplicateOf()
+	}
+	skip := tag.DuplicateOf != "" && cfg.Duplicates == DupSkip
+	if skip {
+		// The content is already filed, so it takes no new name
+		tag.NewPDF = tag.DuplicateOf
+	} else if tag.renames() {
+		tag.renameBase(cfg.Log)
 	}
 
 	match := filepath.Base(tag.NewPDF) == filepath.Base(path)
-	// If we want to write the text file as well
-	if *writetext && (!*tagcvtonly || match) && isNumericName {
-		err = ioutil.WriteFile(tag.TextFileName, []byte(tag.Text), os.ModePerm)
-		if err != nil {
-			log.Fatalln("writing", tag.TextFileName, err)
-		}
+	if (!cfg.TagOnly || match) && isNumericName {
+		tag.AddToAllTags = true
 	}
-
-	// If we want to write symlinks to original
-	if *symlink {
-		err := os.Symlink(path, tag.NewPDF)
+	if skip || tag.DuplicateOf == tag.NewPDF || sameFile(path, tag.NewPDF) {
+		// Already filed, perhaps by an earlier run under the same name, or
+		// found in the output itself
+		return nil
+	}
+	if cfg.DryRun {
+		// Named as a real run would, but nothing is written
+		tag.addHash()
+		return nil
+	}
+	// If we want to write the text file as well
+	if cfg.WriteText && (!cfg.TagOnly || match) && isNumericName {
+		err = p.mkdirAll(filepath.Dir(tag.TextFileName))
 		if err != nil {
-			log.Fatalln("symlink", path, tag.NewPDF, err)
+			return fileError(KindWrite, StageText, tag.TextFileName, err)
 		}
-	} else {
-		// Otherwise write new PDF file
-		bytes, err := ioutil.ReadFile(path)
+		// Undo removes what the run wrote, so it must not write over anything
+		err = writeNewFileAtomic(tag.TextFileName, []byte(tag.Text), cfg.FileMode)
 		if err != nil {
-			log.Fatalln("reading", path, err)
+			return fileError(KindWrite, StageText, tag.TextFileName, err)
 		}
-		err = ioutil.WriteFile(tag.NewPDF, bytes, os.ModePerm)
+		err = p.journal.record(OpText, "", tag.TextFileName, hashBytes([]byte(tag.Text)))
 		if err != nil {
-			log.Fatalln("writing", tag.NewPDF, err)
+			return fileError(KindWrite, StageJournal, p.journal.path, err)
 		}
 	}
 
-	if (!*tagcvtonly || match) && isNumericName {
-		tag.AddToAllTags = true
+	// Templates may place the new PDF in a subdirectory
+	err = p.mkdirAll(filepath.Dir(tag.NewPDF))
+	if err != nil {
+		return fileError(KindWrite, StageRename, tag.NewPDF, err)
 	}
-
+	// Copy, link or move the PDF to its new name, or link a duplicate to
+	// the copy already filed
+	mode, src := cfg.Mode, path
+	if tag.DuplicateOf != "" && cfg.Duplicates == DupLink {
+		mode, src = ModeHardlink, tag.DuplicateOf
+	}
+	hash, err := placePDF(mode, src, tag.NewPDF, cfg.FileMode)
+	if err != nil {
+		return err
+	}
+	tag.Mode = mode
+	err = p.journal.record(mode, src, tag.NewPDF, hash)
+	if err != nil {
+		return fileError(KindWrite, StageJournal, p.journal.path, err)
+	}
+	tag.addHash()
+	return nil
 }
-func (tag *OutputTag) processFileAndRename() {
+
+func (tag *OutputTag) processFileAndRename(ctx context.Context) error {
 	// Get the text from the PDF file
 	start := time.Now()
 	path := tag.OriginalPDF
-	text := processFile(path)
+	text, pages, pageErrs, err := tag.proc.processFile(ctx, path)
+	if err != nil {
+		return err
+	}
+	for _, err := range pageErrs {
+		tag.FailedPages = append(tag.FailedPages, err.(*FileError).Page)
+	}
+	tag.pageErrs = pageErrs
 	tag.Text = text
+	tag.Pages = pages
 	dur := time.Now().Sub(start)
 	if dur > time.Millisecond*500 {
-		fmt.Println(path, dur)
+		fmt.Fprintln(tag.proc.cfg.Log, path, dur)
 	}
+	tag.processText()
+	return nil
+}
+
+// Find the date, words, keywords and MinHash signature of tag.Text
+func (tag *OutputTag) processText() {
+	text := tag.Text
 	tag.FirstDate = findFirstDate(text)
 
 	words := strings.Fields(text)
@@ -249,6 +361,8 @@ func (tag *OutputTag) processFileAndRename() {
 		tag.Words = append(tag.Words, string(runes))
 
 	}
+	// Shingles need the words in order, before they are sorted and made unique
+	tag.signature = minhash(tag.Words)
 	seen := make(map[string]bool)
 	j := 0
 	for _, w := range tag.Words {
@@ -262,16 +376,31 @@ func (tag *OutputTag) processFileAndRename() {
 	tag.Words = tag.Words[:j]
 	sort.Strings(tag.Words)
 
-	// Look for keywords in the text
-	lctext := strings.ToLower(text)
-	for k := range keywords {
-		if strings.Contains(lctext, k) {
+	tag.matchKeywords()
+}
+
+// Look for keywords in the text
+func (tag *OutputTag) matchKeywords() {
+	m := newMatchText(tag.Text)
+	for k, t := range tag.proc.keywords {
+		if dist, ok := t.match(m); ok {
 			tag.Tags[k] = true
+			if dist >= 0 {
+				if tag.Fuzzy == nil {
+					tag.Fuzzy = make(map[string]int)
+				}
+				tag.Fuzzy[k] = dist
+			}
 		}
 	}
 }
 
-func processFile(file string) string {
+// Return the text of the PDF and its page count, or a *FileError. Pages
+// whose text cannot be extracted are left out, with a *FileError for each
+// in pageErrs; if no page can be extracted the PDF has failed. A PDF still
+// being opened when ctx is done, or a page still being read then or after
+// cfg.PageTimeout, abandons the PDF.
+func (p *Processor) processFile(ctx context.Context, file string) (text string, numpages int, pageErrs []error, err error) {
 	var tags OutputTag
 	pw := func() string {
 		return ""
@@ -280,36 +409,41 @@ func processFile(file string) string {
 	tags.NewPDF = file
 
 	f, err := os.Open(file)
-	defer f.Close()
 	if err != nil {
-		log.Fatal(err)
+		return "", 0, nil, fileError(KindOpen, StageOpen, file, err)
 	}
+	defer f.Close()
 	st, err := f.Stat()
 	if err != nil {
-		log.Fatal(err)
+		return "", 0, nil, fileError(KindOpen, StageOpen, file, err)
 	}
 
 	defer func() {
 		if r := recover(); r != nil {
-			fmt.Println(">>>>>>>>> Panic recovery for", file, r)
+			text, numpages, pageErrs = "", 0, nil
+			err = &FileError{Kind: KindParse, Stage: StageDecrypt, Path: file,
+				Err: fmt.Errorf("panic: %v", r), Stack: string(debug.Stack())}
 		}
 	}()
-	r, err := pdf.NewReaderEncrypted(f, st.Size(), pw)
+	r, err := openReader(ctx, f, st.Size(), pw, file)
 	if err != nil {
-		if err == pdf.ErrInvalidPassword {
-			log.Fatal("password not found")
-		}
-		log.Println("error reading", file, err)
+		return "", 0, nil, err
 	}
 	//	fmt.Printf("%#v\n", r.Trailer())
-	numpages := r.NumPage()
+	numpages = r.NumPage()
 	//	fmt.Println("Pages:", numpages)
 	var pages []string
 
 	for i := 1; i <= numpages; i++ {
 		//		fmt.Println(i)
-		page := r.Page(i)
-		pageText := getText(&page)
+		pageText, err := p.pageText(ctx, r, file, i)
+		if fe, ok := err.(*FileError); ok && (fe.Kind == KindTimeout || fe.Kind == KindCanceled) {
+			return "", numpages, nil, err
+		}
+		if err != nil {
+			pageErrs = append(pageErrs, err)
+			continue
+		}
 		pStrings := strings.Split(pageText, " ")
 		var strCount, goodCount int
 		for _, str := range pStrings {
@@ -326,6 +460,9 @@ func processFile(file string) string {
 		}
 		//		fmt.Println()
 	}
+	if len(pageErrs) > 0 && len(pageErrs) == numpages {
+		return "", numpages, nil, pageErrs[0]
+	}
 	input := strings.Join(pages, "\n")
 	alltext := make([]rune, len(input))
 	i := 0
@@ -335,7 +472,94 @@ func processFile(file string) string {
 			i++
 		}
 	}
-	return string(alltext)
+	return string(alltext), numpages, pageErrs, nil
+}
+
+// newReader opens a PDF for reading; tests replace it
+var newReader = pdf.NewReaderEncrypted
+
+// openReader opens the PDF f, of size bytes, or returns a *FileError if it
+// cannot be read or decrypted, the reader panics, or it is still reading
+// the cross-reference table when ctx is done. As with pageText, a reader
+// that is abandoned is left to finish on its own.
+func openReader(ctx context.Context, f io.ReaderAt, size int64, pw func() string, file string) (*pdf.Reader, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, ctxError(err, StageDecrypt, 0, file)
+	}
+	type opened struct {
+		r   *pdf.Reader
+		err error
+	}
+	open := newReader
+	done := make(chan opened, 1)
+	go func() {
+		var result opened
+		defer func() {
+			if rec := recover(); rec != nil {
+				result.err = &FileError{Kind: KindParse, Stage: StageDecrypt, Path: file,
+					Err: fmt.Errorf("panic: %v", rec), Stack: string(debug.Stack())}
+			}
+			done <- result
+		}()
+		r, err := open(f, size, pw)
+		if err == pdf.ErrInvalidPassword {
+			err = fileError(KindEncrypted, StageDecrypt, file, err)
+		} else if err != nil {
+			err = fileError(KindParse, StageDecrypt, file, err)
+		}
+		result = opened{r, err}
+	}()
+	select {
+	case result := <-done:
+		return result.r, result.err
+	case <-ctx.Done():
+		return nil, ctxError(ctx.Err(), StageDecrypt, 0, file)
+	}
+}
+
+// pageText returns the text of page i of r, the PDF file, or a *FileError
+// if the PDF reader panics on it or is still reading it when ctx is done or
+// cfg.PageTimeout has passed. A page that times out is left to the reader,
+// which cannot be interrupted, but no longer holds up the caller. The
+// error has the time spent on the page.
+func (p *Processor) pageText(ctx context.Context, r *pdf.Reader, file string, i int) (text string, err error) {
+	start := time.Now()
+	defer func() {
+		if fe, ok := err.(*FileError); ok {
+			fe.Elapsed = time.Since(start)
+		}
+	}()
+	if p.cfg.PageTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.cfg.PageTimeout)
+		defer cancel()
+	}
+	if err := ctx.Err(); err != nil {
+		return "", ctxError(err, pageStage(i), i, file)
+	}
+	type page struct {
+		text string
+		err  error
+	}
+	done := make(chan page, 1)
+	go func() {
+		var result page
+		defer func() {
+			if rec := recover(); rec != nil {
+				result.err = &FileError{Kind: KindParse, Stage: pageStage(i), Page: i, Path: file,
+					Err: fmt.Errorf("panic: %v", rec), Stack: string(debug.Stack())}
+			}
+			done <- result
+		}()
+		pg := r.Page(i)
+		result.text = p.getText(&pg)
+	}()
+	select {
+	case result := <-done:
+		return result.text, result.err
+	case <-ctx.Done():
+		return "", ctxError(ctx.Err(), pageStage(i), i, file)
+	}
 }
 
 // Given a Page, return a string containing the best guess of the white space separation for
@@ -345,7 +569,7 @@ func processFile(file string) string {
 // 2. If the successive characters are on the same line, if the new character is more than 1.5 the width
 // of the prior character after the prior character, add a space.
 // 3. Add the new character, then finally return the aggregated string.
-func getText(page *pdf.Page) string {
+func (p *Processor) getText(page *pdf.Page) string {
 	var prevText pdf.Text
 	first := 0
 	alltext := page.Content().Text
@@ -386,8 +610,8 @@ func getText(page *pdf.Page) string {
 					if i > first {
 						dxs = append(dxs, gap)
 					}
-					if *debug {
-						if len(linemap) == 0 || linemap[lineno] == true {
+					if p.cfg.Debug {
+						if len(p.linemap) == 0 || p.linemap[lineno] == true {
 							fmt.Printf("%.1f/%.1f/%.1f/%s ",
 								here.FontSize,
 								here.X, gap, here.S)
@@ -401,7 +625,7 @@ func getText(page *pdf.Page) string {
 					median = 0.0
 				}
 				ret += fmt.Sprint(alltext[first].S)
-				if *debug {
+				if p.cfg.Debug {
 					dout += fmt.Sprint(alltext[first].S)
 				}
 
@@ -417,18 +641,18 @@ func getText(page *pdf.Page) string {
 					if gap > median+prior.FontSize/5.0 ||
 						now.Font != prior.Font {
 						//						now.FontSize != prior.FontSize {
-						if *debug {
+						if p.cfg.Debug {
 							dout += fmt.Sprint(" ")
 						}
 						ret += fmt.Sprint(" ")
 					}
-					if *debug {
+					if p.cfg.Debug {
 						dout += fmt.Sprint(now.S)
 					}
 					ret += fmt.Sprint(now.S)
 					prior = now
 				}
-				if *debug && (len(linemap) == 0 || linemap[lineno]) {
+				if p.cfg.Debug && (len(p.linemap) == 0 || p.linemap[lineno]) {
 					fmt.Println(dout)
 					dout = ""
 				}
@@ -443,7 +667,6 @@ func getText(page *pdf.Page) string {
 	return ret
 }
 
-var monthRE string
 var dateRE *regexp.Regexp
 var expRE1 []*regexp.Regexp
 
@@ -461,29 +684,11 @@ func init() {
 		months = append(months, m.String()[:3])
 	}
 	monthRE := "(" + strings.Join(months, "|") + ")"
-	//	fmt.Println(mstr)
-	//	monthRE = regexp.MustCompile(mstr)
 	dateRE = regexp.MustCompile(`(?i)((` + monthRE + `\d\d?|\d\d?` + monthRE + `),?(19|20)\d{2}|\d\d?\/\d\d?\/(19|20)?(\d\d)|20\d\d\/\d\d/\\d\d)`)
 
 	for _, restr := range expStrs {
 		expRE1 = append(expRE1, regexp.MustCompile(restr))
 	}
-
-	for k, v := range renameKeys {
-		if len(v) == 1 {
-			vp := strings.Split(v[0], "-")
-			v = append(v, vp...)
-			renameKeys[k] = v
-		}
-		for i, name := range v {
-			if i == 0 {
-				continue
-			}
-			lcname := strings.ToLower(name)
-			v[i] = lcname
-			keywords[lcname] = true
-		}
-	}
 }
 
 var dateFormats = []string{
@@ -499,61 +704,89 @@ var dateFormats = []string{
 // Find the first plausible date string in the text. Convert it to a consistent
 // date string and return that
 func findFirstDate(text string) string {
+	date, _ := traceFirstDate(text)
+	return date
+}
 
-	dateFind := func(text string) string {
+// dateAttempt is one string findFirstDate tried to parse as a date
+type dateAttempt struct {
+	Squeezed bool   // Found in the text with its spaces removed
+	Found    string // What dateRE matched
+	Parsed   string // Found without commas and with spaces rehydrated
+	Format   string // The layout that parsed it, if any
+}
+
+// traceFirstDate is findFirstDate, also returning the attempts it made
+func traceFirstDate(text string) (string, []dateAttempt) {
+	var attempts []dateAttempt
+
+	dateFind := func(text string, squeezed bool) string {
 		var cvt time.Time
 		var err error
 		date := dateRE.FindString(text)
 		dateOut := ""
 		if date != "" {
+			attempt := dateAttempt{Squeezed: squeezed, Found: date}
 			//			date = date[:len(date)-1]
 			date = strings.ToLower(strings.Replace(date, ",", "", 1))
 			// Rehydrate spaces in the date string
 			for _, re := range expRE1 {
 				date = re.ReplaceAllString(date, "$1 $2")
 			}
+			attempt.Parsed = date
 			// Look for a format that we parse correctly
 			for _, fmt := range dateFormats {
 				cvt, err = time.Parse(fmt, date)
 				if err == nil {
 					dateOut = cvt.Format("-2006-Jan-2")
+					attempt.Format = fmt
 					break
 				}
 			}
+			attempts = append(attempts, attempt)
 		}
 		return dateOut
 	}
 
-	date := dateFind(text)
+	date := dateFind(text, false)
 	if date == "" {
 		t1 := strings.Replace(text, " ", "", -1)
-		date = dateFind(t1)
+		date = dateFind(t1, true)
 	}
-	return date
+	return date, attempts
 }
 
 // OutputTag does
 type OutputTag struct {
-	OriginalPDF  string          // Path to original pdf file
-	Output       string          // Output path directory
-	NewPDF       string          // Name of new pdf file
-	TextFileName string          // Name of text file name
-	FirstDate    string          // If we found a date, it's here in 'Jan 2 2006' format
-	Text         string          // The text from the file
-	Tags         map[string]bool // What keywords were found in this file
-	Words        []string        // Words found
-	Renamed      bool            // Was there renaming
-	AddToAllTags bool            // Tags should be added to composite
-	WG           *sync.WaitGroup // The WaitGroup to use
-	tagChan      chan OutputTag  // Control channel
-	Mutex        *sync.Mutex     // Interlock to newfilenames
+	OriginalPDF   string          // Path to original pdf file
+	Output        string          // Output path directory
+	NewPDF        string          // Name of new pdf file
+	TextFileName  string          // Name of text file name
+	FirstDate     string          // If we found a date, it's here in 'Jan 2 2006' format
+	Text          string          // The text from the file
+	Pages         int             // Page count of the PDF
+	FailedPages   []int           // Pages whose text could not be extracted
+	Tags          map[string]bool // What keywords were found in this file
+	Fuzzy         map[string]int  // Edit distance of keywords only found fuzzily
+	Words         []string        // Words found
+	Renamed       bool            // Was there renaming
+	Mode          string          // How the PDF was put at NewPDF: copy, symlink, hardlink or move
+	SHA256        string          // Hash of the PDF's content
+	DuplicateOf   string          // The PDF in the output with the same content, if any
+	Rule          string          // The rule that named the file
+	Category      string          // and its category
+	Labels        []string        // and labels
+	Score         int             // Score of the rule that named the file
+	RunnerUp      string          // Best scoring rule with another name, if any
+	RunnerUpScore int             // and its score
+	AddToAllTags  bool            // Tags should be added to composite
+	proc          *Processor      // The Processor naming this file
+	pageErrs      []error         // Why FailedPages failed
+	signature     []uint64        // MinHash of the text, for finding near duplicates
 }
 
-var keywords = make(map[string]bool)
-
-var allWords = make(map[string]int)
-
-func (tag *OutputTag) renameBase() {
+// renameBase names the tag by its best rule, noting a tie on w.
+func (tag *OutputTag) renameBase(w io.Writer) {
 	var newbase string
 
 	convert := func(path *string) {
@@ -562,38 +795,44 @@ func (tag *OutputTag) renameBase() {
 			newbase+filepath.Ext(*path))
 	}
 	if len(tag.Tags) > 0 {
-		// renamekeys is read-only
-		for _, arr := range renameKeys {
-			newbase1 := arr[0]
-			newbase = newbase1 + tag.FirstDate
-			success := true
-			for _, item := range arr[1:] {
-				if _, ok := tag.Tags[item]; ok == false {
-					success = false
-					break
-				}
+		// rules are read-only
+		p := tag.proc
+		best, runnerUp := p.bestRule(tag.Tags)
+		if runnerUp.Rule != nil {
+			tag.RunnerUp = runnerUp.Rule.Name
+			tag.RunnerUpScore = runnerUp.Score
+			if runnerUp.Score == best.Score {
+				fmt.Fprintln(w, tag.OriginalPDF, "tie between", best.Rule.Name,
+					"and", runnerUp.Rule.Name, "at", best.Score)
 			}
-			// We are still "success" if we did not have a miss looking up the tags
-			if success {
-				// Renumber file names if necessary
-				tag.Mutex.Lock()
-				if newFileNames[newbase] == true {
-					for suffix := 1; true; suffix++ {
-						nextName := fmt.Sprintf("%s-%d", newbase, suffix)
-						if newFileNames[nextName] {
-							continue
-						}
-						newbase = nextName
-						break
+		}
+		if best.Rule != nil {
+			newbase = tag.newName(best.Rule)
+			tag.Rule = best.Rule.Name
+			tag.Category = best.Rule.Category
+			tag.Labels = best.Rule.Labels
+			tag.Score = best.Score
+			// Renumber file names if necessary, within the directory
+			// the name places the file in
+			subdir, name := path.Split(newbase)
+			p.mu.Lock()
+			names := p.takenNames(filepath.Join(filepath.Dir(tag.NewPDF), filepath.FromSlash(subdir)))
+			if names[name] == true {
+				for suffix := 1; true; suffix++ {
+					nextName := fmt.Sprintf("%s-%d", name, suffix)
+					if names[nextName] {
+						continue
 					}
+					name = nextName
+					break
 				}
-				convert(&tag.TextFileName)
-				convert(&tag.NewPDF)
-				tag.Renamed = true
-				newFileNames[newbase] = true
-				tag.Mutex.Unlock()
-				break
 			}
+			newbase = filepath.Join(filepath.FromSlash(subdir), name)
+			convert(&tag.TextFileName)
+			convert(&tag.NewPDF)
+			tag.Renamed = true
+			names[name] = true
+			p.mu.Unlock()
 		}
 	} else {
 		// If no text associated with file, sad.
@@ -1,11 +1,85 @@
 package main
 
 import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
 	"grier/pdftext"
+
+	flag "github.com/spf13/pflag"
 )
 
 func main() {
 	//defer profile.Start(profile.MemProfile, profile.CPUProfile, profile.ProfilePath(".")).Stop()
 
-	pdftext.Run()
+	cfg, cmd, err := parseFlags(os.Args[1:])
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if err := pdftext.Run(cfg, cmd); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+// parseFlags turns the command line into the Config and Command to run.
+// Use of the 'files' flag overrides the 'dir' scan.
+func parseFlags(args []string) (pdftext.Config, pdftext.Command, error) {
+	cfg := pdftext.DefaultConfig()
+	var cmd pdftext.Command
+	flags := flag.NewFlagSet("pdftext", flag.ExitOnError)
+	//	debug = flags.BoolP("debug", "d", false, "Print only uncategorized lines")
+	flags.StringVarP(&cmd.Dir, "dir", "d", ".", "Descend into this directory")
+	flags.StringArrayVarP(&cmd.Files, "files", "f", []string{}, "Access these specific files")
+	rulesFile := flags.String("rules", "", "Load rename rules from this YAML, TOML or JSON file")
+	progress := flags.Bool("progress", true, "Report progress of directory scans on standard error")
+	flags.StringVar(&cmd.Plan, "plan", "", "With --dry-run, also write the planned renames to this JSON file")
+	flags.StringVar(&cmd.Undo, "run", "", "The run for undo to reverse, as printed at the end of it; tags.json and the other reports are not reverted")
+	fileMode := flags.String("file-mode", fmt.Sprintf("%#o", cfg.FileMode), "Permissions of files written, in octal")
+	dirMode := flags.String("dir-mode", fmt.Sprintf("%#o", cfg.DirMode), "Permissions of directories made, in octal")
+	flags.StringVarP(&cfg.Output, "output", "o", "", "Place PDFs and text in this directory")
+	flags.BoolVarP(&cfg.WriteText, "text", "t", false, "Print recovered text")
+	flags.BoolVarP(&cfg.RenameNewOnly, "renamenew", "r", cfg.RenameNewOnly, "Rename only new timestamp filenames")
+	flags.BoolVarP(&cfg.Debug, "debug", "b", false, "Debug")
+	symlink := flags.BoolP("symlink", "s", false, "Create symlink to original PDF, as --mode=symlink")
+	flags.StringVar(&cfg.Mode, "mode", cfg.Mode, "Put PDFs at their new names by copy, symlink, hardlink or move")
+	flags.StringVar(&cfg.Duplicates, "duplicates", cfg.Duplicates, "PDFs whose content is already in the output: keep, skip, or link to the existing copy")
+	flags.Float64Var(&cfg.Similarity, "similarity", cfg.Similarity, "Report texts at least this alike, 0 to 1, as near duplicates; 0 for none")
+	flags.BoolVar(&cfg.DryRun, "dry-run", false, "Print the planned renames without writing anything")
+	flags.IntSliceVarP(&cfg.Lines, "line", "l", []int{}, "Lines to show in debug")
+	flags.BoolVarP(&cfg.TagOnly, "tagonly", "n", cfg.TagOnly, "Write tags only for unmatched PDFs")
+	flags.IntVarP(&cfg.Threads, "threads", "c", 0, "Count of concurrent threads for processing files")
+	flags.BoolVar(&cfg.Ordered, "ordered", false, "Name files in the order they are found, so numbering is repeatable")
+	flags.DurationVar(&cfg.FileTimeout, "timeout", cfg.FileTimeout, "Give up on a PDF after this long, 0 for never")
+	flags.DurationVar(&cfg.PageTimeout, "page-timeout", cfg.PageTimeout, "Give up on a PDF after this long on one page, 0 for never")
+	flags.IntVar(&cfg.Fuzzy, "fuzzy", 0, "Match rule terms within this edit distance, ignoring white space")
+	flags.StringVar(&cfg.Template, "template", cfg.Template, "Name renamed files with this template, e.g. {rule}-{date:2006-01-02}")
+	flags.Parse(args)
+	cmd.Args = flags.Args()
+	if *symlink {
+		cfg.Mode = pdftext.ModeSymlink
+	}
+	for _, m := range []struct {
+		flag string
+		mode *os.FileMode
+	}{{*fileMode, &cfg.FileMode}, {*dirMode, &cfg.DirMode}} {
+		perm, err := strconv.ParseUint(m.flag, 8, 32)
+		if err != nil || perm&^uint64(os.ModePerm) != 0 {
+			return cfg, cmd, fmt.Errorf("bad permissions %q, want octal like 0644", m.flag)
+		}
+		*m.mode = os.FileMode(perm)
+	}
+	if *rulesFile != "" {
+		rs, err := pdftext.LoadRules(*rulesFile)
+		if err != nil {
+			return cfg, cmd, err
+		}
+		cfg.Rules = rs
+	}
+	cfg.Log = os.Stdout
+	if *progress {
+		cfg.Progress = pdftext.NewProgress(os.Stderr)
+	}
+	return cfg, cmd, nil
 }
@@ -0,0 +1,37 @@
+package pdftext
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// planEntry is a rename a --dry-run would have made.
+type planEntry struct {
+	OriginalPDF string
+	NewPDF      string
+	Rule        string `json:",omitempty"` // The rule that named it, if any
+}
+
+// writePlan writes the planned renames to w, one per line in order of the
+// original name, and to path as JSON, with permissions perm, if path is
+// not empty.
+func writePlan(w io.Writer, path string, plan []planEntry, perm os.FileMode) error {
+	sort.Slice(plan, func(i, j int) bool { return plan[i].OriginalPDF < plan[j].OriginalPDF })
+	for _, e := range plan {
+		fmt.Fprintf(w, "%s -> %s\n", e.OriginalPDF, e.NewPDF)
+	}
+	if path == "" {
+		return nil
+	}
+	if plan == nil {
+		plan = []planEntry{}
+	}
+	bytes, err := json.MarshalIndent(plan, " ", "")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, bytes, perm)
+}
@@ -0,0 +1,318 @@
+package pdftext
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config controls how a Processor extracts, names and files PDFs. The
+// pdftext command fills one in from its flags; DefaultConfig gives the
+// same defaults.
+type Config struct {
+	Output        string        // Place PDFs and text in this directory
+	WriteText     bool          // Also write the recovered text of each PDF
+	RenameNewOnly bool          // Rename only new timestamp filenames
+	TagOnly       bool          // Write tags only for unmatched PDFs
+	Mode          string        // How PDFs are put at their new names; "" for ModeCopy
+	FileMode      os.FileMode   // Permissions of files written; 0 for 0644
+	DirMode       os.FileMode   // Permissions of directories made; 0 for 0755
+	Duplicates    string        // What to do with PDFs already in the output; "" for DupKeep
+	Similarity    float64       // How alike texts must be, 0 to 1, to be near duplicates; 0 to not look
+	DryRun        bool          // Name PDFs as usual but write nothing
+	RunID         string        // Journal what is written under this ID, for undo
+	Threads       int           // Files ProcessDir works on at once; 0 for half the CPUs
+	Ordered       bool          // ProcessDir names and returns files in walk order
+	Progress      *Progress     // Where ProcessDir reports progress, if anywhere
+	FileTimeout   time.Duration // Longest to spend on one PDF; 0 for no limit
+	PageTimeout   time.Duration // Longest to spend on one page; 0 for no limit
+	Log           io.Writer     // Where ties and slow PDFs are noted; nil for nowhere
+	Rules         []Rule        // The rule table; nil for the compiled one
+	Fuzzy         int           // Edit distance for fuzzy term matching; 0 for exact
+	Template      string        // Name template for renamed files; "" for defaultTemplate
+	Debug         bool          // Print the layout of each line of text
+	Lines         []int         // Lines to show in debug, all if empty
+}
+
+// DefaultConfig returns the configuration of the pdftext command run
+// without flags.
+func DefaultConfig() Config {
+	return Config{
+		RenameNewOnly: true,
+		TagOnly:       true,
+		Mode:          ModeCopy,
+		FileMode:      defaultFileMode,
+		DirMode:       defaultDirMode,
+		Duplicates:    DupKeep,
+		Similarity:    defaultSimilarity,
+		FileTimeout:   2 * time.Minute,
+		PageTimeout:   30 * time.Second,
+		Template:      defaultTemplate,
+	}
+}
+
+// Processor extracts the text of PDFs, names them by its rules and files
+// them in the output directory. It is safe for concurrent use; the names
+// it hands out never collide with each other or with PDFs already there.
+type Processor struct {
+	cfg      Config
+	rules    []Rule           // Compiled from cfg.Rules, in priority order
+	keywords map[string]*term // Every term of every rule, by spec
+	linemap  map[int]bool
+	journal  *journal // nil unless cfg.RunID is set
+
+	mu sync.Mutex
+	// Names, without .pdf, taken in each output directory
+	newFileNames map[string]map[string]bool
+	// The PDFs in the output with each content hash, once seedHashes has run
+	hashes map[string][]string
+}
+
+// NewProcessor checks and compiles cfg into a Processor.
+func NewProcessor(cfg Config) (*Processor, error) {
+	if cfg.Template == "" {
+		cfg.Template = defaultTemplate
+	}
+	if err := checkTemplate(cfg.Template); err != nil {
+		return nil, fmt.Errorf("template %v", err)
+	}
+	if cfg.Log == nil {
+		cfg.Log = ioutil.Discard
+	}
+	if cfg.Mode == "" {
+		cfg.Mode = ModeCopy
+	}
+	if err := checkMode(cfg.Mode); err != nil {
+		return nil, err
+	}
+	if cfg.Duplicates == "" {
+		cfg.Duplicates = DupKeep
+	}
+	if err := checkDuplicates(cfg.Duplicates); err != nil {
+		return nil, err
+	}
+	if cfg.Similarity < 0 || cfg.Similarity > 1 {
+		return nil, fmt.Errorf("similarity %v is not between 0 and 1", cfg.Similarity)
+	}
+	if cfg.FileMode == 0 {
+		cfg.FileMode = defaultFileMode
+	}
+	if cfg.DirMode == 0 {
+		cfg.DirMode = defaultDirMode
+	}
+	if cfg.Threads <= 0 {
+		cfg.Threads = (runtime.NumCPU() + 1) / 2
+	}
+	raw := cfg.Rules
+	if raw == nil {
+		raw = rulesFromKeys(renameKeys)
+	}
+	rules, keywords, err := compileRules(raw, cfg.Fuzzy)
+	if err != nil {
+		return nil, err
+	}
+	p := &Processor{
+		cfg:          cfg,
+		rules:        rules,
+		keywords:     keywords,
+		linemap:      make(map[int]bool),
+		newFileNames: make(map[string]map[string]bool),
+	}
+	for _, i := range cfg.Lines {
+		p.linemap[i] = true
+	}
+	if cfg.RunID != "" && !cfg.DryRun {
+		p.journal = &journal{path: filepath.Join(cfg.Output, journalName), run: cfg.RunID,
+			perm: cfg.FileMode}
+	}
+	return p, nil
+}
+
+// Close closes the journal, if there is one.
+func (p *Processor) Close() error {
+	return p.journal.Close()
+}
+
+// Result is the outcome of processing one PDF.
+type Result struct {
+	*OutputTag
+	Err error // A *FileError if the PDF could not be processed
+}
+
+// ProcessFile extracts, names and files the PDF at path, giving up after
+// cfg.FileTimeout. A failure to do so is a *FileError in the Result; the
+// error returned is only for ctx being done before it starts.
+func (p *Processor) ProcessFile(ctx context.Context, path string) (*Result, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	j := &job{tag: p.newTag(path)}
+	j.extract(ctx)
+	result := j.file()
+	return &result, nil
+}
+
+// fileContext returns the context for working on one PDF, which ends after
+// cfg.FileTimeout if there is one.
+func (p *Processor) fileContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if p.cfg.FileTimeout > 0 {
+		return context.WithTimeout(ctx, p.cfg.FileTimeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// ProcessDir processes every PDF under dir and sends each result on the
+// returned channel, which is closed when all are done. It is a pipeline: a
+// walker finds the PDFs, cfg.Threads workers extract their text, and a
+// single stage names and writes them, in walk order if cfg.Ordered and
+// otherwise as they are extracted. Each stage waits for the next, so a
+// caller that stops reading results stops the walk; in order, at most
+// 2×cfg.Threads PDFs are in hand while one slow PDF holds up the rest.
+// Cancelling ctx stops the walk, and the PDFs already found come back with
+// KindCanceled errors.
+func (p *Processor) ProcessDir(ctx context.Context, dir string) (<-chan Result, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+	found := make(chan *job)
+	extracted := make(chan *job, p.cfg.Threads)
+	results := make(chan Result, p.cfg.Threads)
+
+	// In order, a PDF found takes a slot until it is filed, so the workers
+	// cannot run far ahead of one that is slow
+	var window chan struct{}
+	if p.cfg.Ordered {
+		window = make(chan struct{}, 2*p.cfg.Threads)
+	}
+	progress := p.cfg.Progress
+	go func() {
+		defer close(found)
+		if progress != nil {
+			defer progress.Walked()
+		}
+		seq := 0
+		filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err != nil || info.IsDir() || !strings.HasSuffix(path, ".pdf") {
+				return nil
+			}
+			if window != nil {
+				select {
+				case window <- struct{}{}:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			if progress != nil {
+				progress.Found()
+			}
+			found <- &job{seq: seq, tag: p.newTag(path)}
+			seq++
+			return nil
+		})
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < p.cfg.Threads; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range found {
+				j.extract(ctx)
+				extracted <- j
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(extracted)
+	}()
+
+	go func() {
+		defer close(results)
+		send := func(j *job) {
+			result := j.file()
+			if progress != nil {
+				progress.Done(result)
+			}
+			results <- result
+			if window != nil {
+				<-window
+			}
+		}
+		// Jobs extracted ahead of their turn, by seq
+		pending := make(map[int]*job)
+		next := 0
+		for j := range extracted {
+			if !p.cfg.Ordered {
+				send(j)
+				continue
+			}
+			pending[j.seq] = j
+			for j := pending[next]; j != nil; j = pending[next] {
+				delete(pending, next)
+				send(j)
+				next++
+			}
+		}
+	}()
+	return results, nil
+}
+
+// job is a PDF on its way through ProcessDir
+type job struct {
+	seq   int // Position in the walk
+	tag   *OutputTag
+	start time.Time
+	err   error
+}
+
+// extract recovers the text of the job's PDF, within cfg.FileTimeout.
+func (j *job) extract(ctx context.Context) {
+	j.start = time.Now()
+	if err := ctx.Err(); err != nil {
+		j.err = ctxError(err, StageOpen, 0, j.tag.OriginalPDF)
+		return
+	}
+	ctx, cancel := j.tag.proc.fileContext(ctx)
+	defer cancel()
+	j.err = j.tag.extract(ctx)
+}
+
+// file names and writes the job's PDF, unless it could not be read at all,
+// and returns its Result. A PDF whose text could not be extracted is filed
+// as notext-NAME, with the extraction error, unless filing it fails too.
+func (j *job) file() Result {
+	if j.err == nil || noText(j.err) {
+		if err := j.tag.file(); err != nil {
+			j.err = err
+		}
+	}
+	if fe, ok := j.err.(*FileError); ok {
+		fe.Elapsed = time.Since(j.start)
+	}
+	return Result{OutputTag: j.tag, Err: j.err}
+}
+
+// newTag starts the OutputTag for the PDF at path, named as it is until
+// renameBase finds it a new name.
+func (p *Processor) newTag(path string) *OutputTag {
+	base := filepath.Base(path)
+	return &OutputTag{
+		OriginalPDF:  path,
+		Output:       p.cfg.Output,
+		TextFileName: filepath.Join(p.cfg.Output, strings.Replace(base, ".pdf", ".txt", 1)),
+		NewPDF:       filepath.Join(p.cfg.Output, base),
+		Tags:         make(map[string]bool),
+		proc:         p,
+	}
+}
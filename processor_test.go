@@ -0,0 +1,403 @@
+package pdftext
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"rcs.io/pdf"
+)
+
+// Processors with different configurations run side by side without
+// sharing rules, templates or taken names
+func TestProcessorsIndependent(t *testing.T) {
+	out, err := ioutil.TempDir("", "proc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(out)
+	a := newTestProcessor(t, []Rule{{Name: "Fios", Terms: []string{"fios"}}})
+	cfg := DefaultConfig()
+	cfg.Rules = []Rule{{Name: "Verizon", Terms: []string{"fios"}}}
+	cfg.Template = "{rule}-{isodate}"
+	b, err := NewProcessor(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(map[*Processor][]string)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	for _, p := range []*Processor{a, b, a, b} {
+		wg.Add(1)
+		go func(p *Processor) {
+			defer wg.Done()
+			tag := p.newTag(filepath.Join("in", "x.pdf"))
+			tag.NewPDF = filepath.Join(out, "x.pdf")
+			tag.Text = "Fios bill Feb 20, 2019"
+			tag.processText()
+			tag.renameBase(ioutil.Discard)
+			mu.Lock()
+			got[p] = append(got[p], filepath.Base(tag.NewPDF))
+			mu.Unlock()
+		}(p)
+	}
+	wg.Wait()
+	for p, want := range map[*Processor]string{a: "Fios-2019-Feb-20", b: "Verizon-2019-02-20"} {
+		names := map[string]bool{want + ".pdf": true, want + "-1.pdf": true}
+		for _, name := range got[p] {
+			if !names[name] {
+				t.Errorf("got %s, want %s or %s-1", name, want, want)
+			}
+			delete(names, name)
+		}
+	}
+
+	if _, err := NewProcessor(Config{Template: "{colour}"}); err == nil {
+		t.Error("want an error for a bad template")
+	}
+	results, err := a.ProcessDir(context.Background(), out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for r := range results {
+		t.Errorf("no PDFs, got %v", r.OriginalPDF)
+	}
+}
+
+// A file that cannot be processed is reported with the kind of failure,
+// and does not stop the rest of the directory
+func TestProcessDirErrors(t *testing.T) {
+	in, err := ioutil.TempDir("", "in")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(in)
+	ioutil.WriteFile(filepath.Join(in, "2019_02_20_10_11_12.pdf"), []byte("not a PDF"), 0644)
+	ioutil.WriteFile(filepath.Join(in, "scan.pdf"), []byte("not a PDF"), 0644)
+	out, err := ioutil.TempDir("", "out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(out)
+	// A directory where scan.pdf goes, so it cannot be written
+	os.Mkdir(filepath.Join(out, "scan.pdf"), 0755)
+
+	cfg := DefaultConfig()
+	cfg.Output = out
+	p, err := NewProcessor(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := p.ProcessDir(context.Background(), in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kinds := make(map[string]ErrorKind)
+	failed := make(map[string][]error)
+	for r := range results {
+		failed[r.OriginalPDF] = []error{r.Err}
+		var fe *FileError
+		if !errors.As(r.Err, &fe) {
+			t.Errorf("%s: got %v, want a *FileError", r.OriginalPDF, r.Err)
+			continue
+		}
+		kinds[filepath.Base(r.OriginalPDF)] = fe.Kind
+	}
+	if got, want := fmt.Sprint(kinds), "map[2019_02_20_10_11_12.pdf:parse scan.pdf:write]"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	// Without its text, the PDF is still filed
+	if _, err := os.Stat(filepath.Join(out, "notext-2019_02_20_10_11_12.pdf")); err != nil {
+		t.Error(err)
+	}
+
+	report := filepath.Join(in, "errors.json")
+	if err := writeErrors(report, failed, 0644); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var records []errorRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, r := range records {
+		got = append(got, fmt.Sprint(filepath.Base(r.File), " ", r.Stage, " ", r.Class, " ", r.Elapsed > 0))
+	}
+	if want := "2019_02_20_10_11_12.pdf decrypt parse true, scan.pdf copy write true"; strings.Join(got, ", ") != want {
+		t.Errorf("errors.json has %s, want %s", strings.Join(got, ", "), want)
+	}
+
+	_, _, _, err = p.processFile(context.Background(), filepath.Join(in, "missing.pdf"))
+	if fe, ok := err.(*FileError); !ok || fe.Kind != KindOpen {
+		t.Errorf("missing file: got %v", err)
+	}
+}
+
+// A panic in the PDF reader on one page is caught and reported for that
+// page alone
+func TestPageTextPanic(t *testing.T) {
+	p := newTestProcessor(t, nil)
+	// Any page of no reader makes the PDF reader panic
+	_, err := p.pageText(context.Background(), nil, "x.pdf", 7)
+	fe, ok := err.(*FileError)
+	if !ok || fe.Page != 7 || fe.Stage != "page 7 extract" || fe.Kind != KindParse || fe.Stack == "" || fe.Elapsed <= 0 {
+		t.Errorf("got %#v", err)
+	}
+}
+
+// A page still being read when its context ends abandons the PDF
+func TestPageTextTimeout(t *testing.T) {
+	p := newTestProcessor(t, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), -time.Second)
+	defer cancel()
+	_, err := p.pageText(ctx, nil, "x.pdf", 2)
+	if fe, ok := err.(*FileError); !ok || fe.Kind != KindTimeout || fe.Page != 2 {
+		t.Errorf("expired: got %#v", err)
+	}
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	_, err = p.pageText(ctx, nil, "x.pdf", 2)
+	if fe, ok := err.(*FileError); !ok || fe.Kind != KindCanceled {
+		t.Errorf("canceled: got %#v", err)
+	}
+}
+
+// A PDF reader stuck opening the file is abandoned at the file deadline
+func TestOpenTimeout(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+	defer func(old func(io.ReaderAt, int64, func() string) (*pdf.Reader, error)) { newReader = old }(newReader)
+	newReader = func(io.ReaderAt, int64, func() string) (*pdf.Reader, error) {
+		<-block
+		return nil, nil
+	}
+	in, err := ioutil.TempDir("", "open")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(in)
+	file := filepath.Join(in, "2019_02_20_10_11_12.pdf")
+	ioutil.WriteFile(file, []byte("%PDF"), 0644)
+
+	p := newTestProcessor(t, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, _, _, err = p.processFile(ctx, file)
+	if fe, ok := err.(*FileError); !ok || fe.Kind != KindTimeout || fe.Stage != StageDecrypt {
+		t.Errorf("got %#v", err)
+	}
+
+	// The PDF is abandoned, not filed or moved
+	cfg := DefaultConfig()
+	cfg.Output = filepath.Join(in, "out")
+	cfg.Mode = ModeMove
+	cfg.FileTimeout = 10 * time.Millisecond
+	p, err = NewProcessor(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := p.ProcessFile(context.Background(), file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fe, ok := r.Err.(*FileError); !ok || fe.Kind != KindTimeout {
+		t.Errorf("ProcessFile: got %v", r.Err)
+	}
+	if _, err := os.Stat(file); err != nil {
+		t.Error("timed out PDF was moved")
+	}
+	if _, err := os.Stat(cfg.Output); !os.IsNotExist(err) {
+		t.Error("timed out PDF was filed")
+	}
+}
+
+// Ordered results come back in walk order, however the workers finish
+func TestProcessDirOrdered(t *testing.T) {
+	in, err := ioutil.TempDir("", "in")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(in)
+	var want []string
+	for i := 0; i < 20; i++ {
+		name := fmt.Sprintf("scan%02d.pdf", i)
+		ioutil.WriteFile(filepath.Join(in, name), []byte("not a PDF"), 0644)
+		want = append(want, name)
+	}
+	out, err := ioutil.TempDir("", "out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(out)
+	for _, ordered := range []bool{true, false} {
+		cfg := DefaultConfig()
+		cfg.Output = filepath.Join(out, fmt.Sprint(ordered))
+		cfg.Threads = 4
+		cfg.Ordered = ordered
+		p, err := NewProcessor(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		results, err := p.ProcessDir(context.Background(), in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for r := range results {
+			if r.Err != nil {
+				t.Error(r.Err)
+			}
+			got = append(got, filepath.Base(r.OriginalPDF))
+		}
+		if !ordered {
+			sort.Strings(got)
+		}
+		if strings.Join(got, " ") != strings.Join(want, " ") {
+			t.Errorf("ordered %v: got %v", ordered, got)
+		}
+	}
+}
+
+// A dry run numbers names as a real run would, but writes nothing
+func TestDryRun(t *testing.T) {
+	out, err := ioutil.TempDir("", "dry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(out)
+	ioutil.WriteFile(filepath.Join(out, "Fios-2019-Feb-20.pdf"), nil, 0644)
+	cfg := DefaultConfig()
+	cfg.Output = out
+	cfg.DryRun = true
+	cfg.Rules = []Rule{{Name: "Fios", Terms: []string{"fios"}}}
+	p, err := NewProcessor(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for i := 0; i < 2; i++ {
+		tag := p.newTag(filepath.Join("in", fmt.Sprintf("2019_02_20_10_11_1%d.pdf", i)))
+		tag.Text = "Fios bill Feb 20, 2019"
+		tag.processText()
+		if err := tag.file(); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, filepath.Base(tag.NewPDF))
+	}
+	if want := "Fios-2019-Feb-20-1.pdf Fios-2019-Feb-20-2.pdf"; strings.Join(got, " ") != want {
+		t.Errorf("got %s, want %s", strings.Join(got, " "), want)
+	}
+	if infos, _ := ioutil.ReadDir(out); len(infos) != 1 {
+		t.Errorf("dry run wrote %d files", len(infos)-1)
+	}
+
+	plan := filepath.Join(out, "plan.json")
+	var buf bytes.Buffer
+	err = writePlan(&buf, plan, []planEntry{{"in/b.pdf", "out/B.pdf", "B"}, {"in/a.pdf", "out/a.pdf", ""}}, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "in/a.pdf -> out/a.pdf\nin/b.pdf -> out/B.pdf\n"; buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+	if data, _ := ioutil.ReadFile(plan); !strings.Contains(string(data), `"Rule": "B"`) {
+		t.Errorf("plan.json has %s", data)
+	}
+}
+
+// Ties are noted on cfg.Log, not printed
+func TestLog(t *testing.T) {
+	out, err := ioutil.TempDir("", "log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(out)
+	var log bytes.Buffer
+	cfg := DefaultConfig()
+	cfg.Output = out
+	cfg.DryRun = true
+	cfg.Log = &log
+	cfg.Rules = []Rule{{Name: "Fios", Terms: []string{"fios"}}, {Name: "Verizon", Terms: []string{"fios"}}}
+	p, err := NewProcessor(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tag := p.newTag("2019_02_20_10_11_12.pdf")
+	tag.Text = "Fios bill Feb 20, 2019"
+	tag.processText()
+	if err := tag.file(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(log.String(), "tie between Fios") {
+		t.Errorf("logged %q", log.String())
+	}
+}
+
+// In order, a PDF stuck extracting holds up the walk rather than letting
+// the workers read every PDF after it
+func TestProcessDirOrderedWindow(t *testing.T) {
+	in, err := ioutil.TempDir("", "in")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(in)
+	for i := 0; i < 20; i++ {
+		ioutil.WriteFile(filepath.Join(in, fmt.Sprintf("2019_02_20_10_11_%02d.pdf", i)), nil, 0644)
+	}
+	block := make(chan struct{})
+	var mu sync.Mutex
+	started := 0
+	defer func(old func(io.ReaderAt, int64, func() string) (*pdf.Reader, error)) { newReader = old }(newReader)
+	newReader = func(f io.ReaderAt, size int64, pw func() string) (*pdf.Reader, error) {
+		mu.Lock()
+		started++
+		mu.Unlock()
+		if strings.HasSuffix(f.(*os.File).Name(), "_00.pdf") {
+			<-block
+		}
+		return nil, errors.New("not a PDF")
+	}
+
+	cfg := DefaultConfig()
+	cfg.Output = filepath.Join(in, "out")
+	cfg.Threads = 2
+	cfg.Ordered = true
+	p, err := NewProcessor(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := p.ProcessDir(context.Background(), in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	mu.Lock()
+	if started > 2*cfg.Threads {
+		t.Errorf("%d PDFs extracted while the first was stuck, want at most %d", started, 2*cfg.Threads)
+	}
+	mu.Unlock()
+	close(block)
+	n := 0
+	for range results {
+		n++
+	}
+	if n != 20 {
+		t.Errorf("got %d results, want 20", n)
+	}
+}
@@ -0,0 +1,128 @@
+package pdftext
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// How often Progress reports, on a terminal and to a log
+const (
+	progressTTYInterval = 200 * time.Millisecond
+	progressLogInterval = 10 * time.Second
+)
+
+// Progress reports how far ProcessDir has got: files found by the walk,
+// processed, renamed and failed, throughput and an estimate of the time
+// left. On a terminal it keeps one line up to date; otherwise it writes a
+// line at intervals. It is safe for concurrent use.
+type Progress struct {
+	w        io.Writer
+	tty      bool
+	interval time.Duration
+	now      func() time.Time
+
+	mu         sync.Mutex
+	start      time.Time
+	last       time.Time // When it last reported
+	walked     bool      // The walk is over, so found is the total
+	found      int
+	processed  int
+	renamed    int
+	failed     int
+	lineLength int // Of the live line, to blank it out
+}
+
+// NewProgress returns a Progress reporting to w, as a live line if w is a
+// terminal.
+func NewProgress(w io.Writer) *Progress {
+	p := &Progress{w: w, interval: progressLogInterval, now: time.Now}
+	if f, ok := w.(*os.File); ok {
+		if st, err := f.Stat(); err == nil && st.Mode()&os.ModeCharDevice != 0 {
+			p.tty = true
+			p.interval = progressTTYInterval
+		}
+	}
+	p.start = p.now()
+	p.last = p.start
+	return p
+}
+
+// Found counts a PDF found by the walk.
+func (p *Progress) Found() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.found++
+	p.report(false)
+}
+
+// Walked records that the walk is over.
+func (p *Progress) Walked() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.walked = true
+}
+
+// Done counts a PDF processed, as in r.
+func (p *Progress) Done(r Result) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.processed++
+	switch {
+	case r.Err != nil:
+		p.failed++
+	case r.Renamed:
+		p.renamed++
+	}
+	p.report(false)
+}
+
+// Finish writes the final counts.
+func (p *Progress) Finish() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.report(true)
+	if p.tty {
+		fmt.Fprintln(p.w)
+	}
+}
+
+// report writes the counts if the interval has passed, or final is set.
+// Call with mu held.
+func (p *Progress) report(final bool) {
+	now := p.now()
+	if !final && now.Sub(p.last) < p.interval {
+		return
+	}
+	p.last = now
+	elapsed := now.Sub(p.start)
+	rate := 0.0
+	if elapsed > 0 {
+		rate = float64(p.processed) / elapsed.Seconds()
+	}
+	eta := "unknown"
+	if final {
+		eta = "done"
+	} else if rate > 0 {
+		left := time.Duration(float64(p.found-p.processed) / rate * float64(time.Second))
+		eta = left.Round(time.Second).String()
+		if !p.walked {
+			// More may yet be found
+			eta = "at least " + eta
+		}
+	}
+	line := fmt.Sprintf("%d found, %d processed, %d renamed, %d failed, %.1f files/s, ETA %s",
+		p.found, p.processed, p.renamed, p.failed, rate, eta)
+	if !p.tty {
+		fmt.Fprintf(p.w, "progress: %s, elapsed %s\n", line, elapsed.Round(time.Second))
+		return
+	}
+	pad := p.lineLength - len(line)
+	if pad < 0 {
+		pad = 0
+	}
+	fmt.Fprintf(p.w, "\r%s%*s", line, pad, "")
+	p.lineLength = len(line)
+}
@@ -0,0 +1,37 @@
+package pdftext
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProgress(t *testing.T) {
+	var buf bytes.Buffer
+	p := NewProgress(&buf)
+	clock := p.start
+	p.now = func() time.Time { return clock }
+	for i := 0; i < 4; i++ {
+		p.Found()
+	}
+	clock = clock.Add(2 * time.Second)
+	p.Done(Result{OutputTag: &OutputTag{Renamed: true}})
+	p.Done(Result{OutputTag: &OutputTag{}, Err: errors.New("bad")})
+	if buf.Len() != 0 {
+		t.Errorf("reported before the interval: %q", buf.String())
+	}
+	clock = clock.Add(progressLogInterval)
+	p.Walked()
+	p.Done(Result{OutputTag: &OutputTag{Renamed: true}})
+	p.Finish()
+	want := "progress: 4 found, 3 processed, 2 renamed, 1 failed, 0.2 files/s, ETA 4s, elapsed 12s\n" +
+		"progress: 4 found, 3 processed, 2 renamed, 1 failed, 0.2 files/s, ETA done, elapsed 12s\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got\n%swant\n%s", got, want)
+	}
+	if strings.Contains(buf.String(), "\r") {
+		t.Error("live line written to a buffer")
+	}
+}
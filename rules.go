@@ -0,0 +1,350 @@
+package pdftext
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// Rule names a kind of document and the terms that must all be present in
+// its text for a PDF to be renamed after it. A rule without terms uses the
+// pieces of its name split on "-", as the compiled table always has. The
+// rule is rejected if any of its Exclude terms is present.
+type Rule struct {
+	Name    string   `json:"name" yaml:"name" toml:"name"`
+	Terms   []string `json:"terms,omitempty" yaml:"terms,omitempty" toml:"terms,omitempty"`
+	Exclude []string `json:"exclude,omitempty" yaml:"exclude,omitempty" toml:"exclude,omitempty"`
+	// Priority raises (or lowers) the rule's score when several rules match
+	Priority int `json:"priority,omitempty" yaml:"priority,omitempty" toml:"priority,omitempty"`
+	// Template overrides --template for files this rule names
+	Template string `json:"template,omitempty" yaml:"template,omitempty" toml:"template,omitempty"`
+	// Dest is the directory under --output for files this rule names,
+	// itself a template, e.g. Medical/{year}
+	Dest string `json:"dest,omitempty" yaml:"dest,omitempty" toml:"dest,omitempty"`
+	// Category, e.g. Medical or Tax, and any further Labels are recorded
+	// in tags.json for the files this rule names
+	Category string   `json:"category,omitempty" yaml:"category,omitempty" toml:"category,omitempty"`
+	Labels   []string `json:"labels,omitempty" yaml:"labels,omitempty" toml:"labels,omitempty"`
+
+	// Terms and Exclude as compiled by compileRules
+	terms   []*term
+	exclude []*term
+}
+
+// Category holds what the rules in a category share. A rule's own Dest
+// wins over its category's, and the category's Labels are added to its own.
+type Category struct {
+	Dest   string   `json:"dest,omitempty" yaml:"dest,omitempty" toml:"dest,omitempty"`
+	Labels []string `json:"labels,omitempty" yaml:"labels,omitempty" toml:"labels,omitempty"`
+}
+
+// RuleFile is the layout of a --rules file in any of the supported formats.
+// Terms may carry the word:, case: and re: prefixes described in match.go.
+//
+//	rules:
+//	  - name: Friends-Forest
+//	    terms: [friends, forest]
+//	  - name: Nepenthe-Electric
+//	    terms: ["word:aps", energy, arizona]
+//	  - name: Nepenthe-Tax
+//	    terms: [yavapai, treasurer, nepenthe]
+//	    exclude: [refund]
+//	    category: Tax
+//	    labels: [Nepenthe, Property]
+//	categories:
+//	  Tax:
+//	    dest: Taxes/{year}
+type RuleFile struct {
+	Rules      []Rule              `json:"rules" yaml:"rules" toml:"rules"`
+	Categories map[string]Category `json:"categories,omitempty" yaml:"categories,omitempty" toml:"categories,omitempty"`
+}
+
+// excludePrefix marks a term in the compiled table as one that must not
+// appear.
+const excludePrefix = "!"
+
+// rulesFromKeys converts the compiled [name, term...] table into rules,
+// filed into categories by ruleCategories.
+func rulesFromKeys(keys [][]string) []Rule {
+	category := make(map[string]string)
+	for c, names := range ruleCategories {
+		for _, name := range names {
+			category[name] = c
+		}
+	}
+	rs := make([]Rule, 0, len(keys))
+	for _, v := range keys {
+		r := Rule{Name: v[0], Category: category[v[0]]}
+		for _, t := range v[1:] {
+			if strings.HasPrefix(t, excludePrefix) {
+				r.Exclude = append(r.Exclude, t[len(excludePrefix):])
+			} else {
+				r.Terms = append(r.Terms, t)
+			}
+		}
+		rs = append(rs, r)
+	}
+	return rs
+}
+
+// compileRules compiles a rule table for matching, leaving rs as it is.
+// Rules without terms take the pieces of their name, terms and exclusions
+// are normalized by parseTerm and may match within fuzzy edits, and every
+// term is gathered into the keywords set.
+func compileRules(rs []Rule, fuzzy int) ([]Rule, map[string]*term, error) {
+	compiled := make([]Rule, len(rs))
+	keywords := make(map[string]*term)
+	for i, r := range rs {
+		if len(r.Terms) == 0 {
+			r.Terms = strings.Split(r.Name, "-")
+		}
+		r.Terms = append([]string(nil), r.Terms...)
+		r.Exclude = append([]string(nil), r.Exclude...)
+		r.terms, r.exclude = nil, nil
+		for _, terms := range []*[]*term{&r.terms, &r.exclude} {
+			specs := r.Terms
+			if terms == &r.exclude {
+				specs = r.Exclude
+			}
+			for j, name := range specs {
+				t, err := parseTerm(name)
+				if err != nil {
+					return nil, nil, fmt.Errorf("rule %d (%s): %v", i+1, r.Name, err)
+				}
+				t.allowEdits(fuzzy)
+				if k, ok := keywords[t.spec]; ok {
+					t = k
+				}
+				specs[j] = t.spec
+				keywords[t.spec] = t
+				*terms = append(*terms, t)
+			}
+		}
+		compiled[i] = r
+	}
+	return compiled, keywords, nil
+}
+
+// ruleMatch is a rule that matched a document and its score.
+type ruleMatch struct {
+	Rule  *Rule
+	Score int
+}
+
+// score reports whether all of the rule's terms and none of its exclusions
+// are in tags, and if so how strongly it matched. Each term counts 10 plus
+// its length, so rules with more and longer terms outrank generic ones, and
+// each step of Priority counts 100.
+func (r *Rule) score(tags map[string]bool) (int, bool) {
+	score := 100 * r.Priority
+	for _, t := range r.terms {
+		if !tags[t.spec] {
+			return 0, false
+		}
+		score += t.score()
+	}
+	for _, t := range r.exclude {
+		if tags[t.spec] {
+			return 0, false
+		}
+	}
+	return score, true
+}
+
+// score is what a term adds to the score of a rule it helps match.
+func (t *term) score() int {
+	return 10 + len(t.text)
+}
+
+// bestRule scores every rule against tags and returns the best match and the
+// best match with a different name. Ties go to the earlier rule, so the
+// runner-up may have the same score as the winner. Rule is nil in either
+// result when there is no such match.
+func (p *Processor) bestRule(tags map[string]bool) (best, runnerUp ruleMatch) {
+	var matches []ruleMatch
+	for i := range p.rules {
+		if score, ok := p.rules[i].score(tags); ok {
+			matches = append(matches, ruleMatch{&p.rules[i], score})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Score > matches[j].Score
+	})
+	if len(matches) == 0 {
+		return
+	}
+	best = matches[0]
+	for _, m := range matches[1:] {
+		if m.Rule.Name != best.Rule.Name {
+			runnerUp = m
+			break
+		}
+	}
+	return
+}
+
+// rulesCommand runs the "pdftext rules" subcommands, returning an error
+// when they find a problem:
+//
+//	rules test DIR [EXPECT]     check the names given to sample documents
+//	rules lint                  check the rule table itself
+//	rules suggest [TAGS [OUT]]  propose rules for unmatched documents
+func (p *Processor) rulesCommand(w io.Writer, args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("usage: pdftext rules test|lint|suggest")
+	}
+	switch args[0] {
+	case "test":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: pdftext rules test DIR [EXPECT]")
+		}
+		dir := args[1]
+		expectFile := filepath.Join(dir, "expect.json")
+		if len(args) > 2 {
+			expectFile = args[2]
+		}
+		failed, err := p.runRulesTest(w, dir, expectFile)
+		if err != nil {
+			return err
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d samples misnamed", failed)
+		}
+	case "lint":
+		// Lint the table as written, not as compileRules normalized it
+		raw := p.cfg.Rules
+		if raw == nil {
+			raw = rulesFromKeys(renameKeys)
+		}
+		if errors := runLint(w, raw); errors > 0 {
+			return fmt.Errorf("%d errors in the rule table", errors)
+		}
+	case "suggest":
+		tagsFile := filepath.Join(p.cfg.Output, "tags.json")
+		if len(args) > 1 {
+			tagsFile = args[1]
+		}
+		out := filepath.Join(filepath.Dir(tagsFile), "suggested-rules.yaml")
+		if len(args) > 2 {
+			out = args[2]
+		}
+		data, err := ioutil.ReadFile(tagsFile)
+		if err != nil {
+			return err
+		}
+		tags := make(map[string]*OutputTag)
+		if err := json.Unmarshal(data, &tags); err != nil {
+			return fmt.Errorf("%s: %v", tagsFile, err)
+		}
+		suggestions := suggestRules(tags)
+		if err := writeSuggestions(out, suggestions, p.cfg.FileMode); err != nil {
+			return err
+		}
+		fmt.Fprintln(w, "Wrote", len(suggestions), "suggested rules to", out)
+	default:
+		return fmt.Errorf("unknown rules command %s", args[0])
+	}
+	return nil
+}
+
+// LoadRules reads a rule table from a YAML, TOML or JSON file, chosen by
+// the file extension. Syntax errors are reported with the offending line.
+func LoadRules(path string) ([]Rule, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rf RuleFile
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		// yaml errors already carry "line N"
+		if err := yaml.UnmarshalStrict(data, &rf); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+	case ".toml":
+		// toml parse errors already carry "line N"
+		md, err := toml.Decode(string(data), &rf)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			var keys []string
+			for _, k := range undecoded {
+				keys = append(keys, k.String())
+			}
+			sort.Strings(keys)
+			return nil, fmt.Errorf("%s: unknown keys %s", path, strings.Join(keys, ", "))
+		}
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&rf); err != nil {
+			offset := dec.InputOffset()
+			switch e := err.(type) {
+			case *json.SyntaxError:
+				offset = e.Offset
+			case *json.UnmarshalTypeError:
+				offset = e.Offset
+			}
+			return nil, fmt.Errorf("%s:%d: %v", path, lineOf(data, offset), err)
+		}
+	default:
+		return nil, fmt.Errorf("%s: unknown rules format %q, want .yaml, .toml or .json",
+			path, filepath.Ext(path))
+	}
+	for i, r := range rf.Rules {
+		if strings.TrimSpace(r.Name) == "" {
+			return nil, fmt.Errorf("%s: rule %d has no name", path, i+1)
+		}
+		if err := checkTemplate(r.Template); err != nil {
+			return nil, fmt.Errorf("%s: rule %d (%s): template %v", path, i+1, r.Name, err)
+		}
+		if err := checkTemplate(r.Dest); err != nil {
+			return nil, fmt.Errorf("%s: rule %d (%s): dest %v", path, i+1, r.Name, err)
+		}
+		for _, terms := range [][]string{r.Terms, r.Exclude} {
+			for _, t := range terms {
+				if strings.TrimSpace(t) == "" {
+					return nil, fmt.Errorf("%s: rule %d (%s) has an empty term", path, i+1, r.Name)
+				}
+				if _, err := parseTerm(t); err != nil {
+					return nil, fmt.Errorf("%s: rule %d (%s): %v", path, i+1, r.Name, err)
+				}
+			}
+		}
+	}
+	if len(rf.Rules) == 0 {
+		return nil, fmt.Errorf("%s: no rules", path)
+	}
+	for name, c := range rf.Categories {
+		if err := checkTemplate(c.Dest); err != nil {
+			return nil, fmt.Errorf("%s: category %s: dest %v", path, name, err)
+		}
+	}
+	for i := range rf.Rules {
+		r := &rf.Rules[i]
+		if c, ok := rf.Categories[r.Category]; ok {
+			if r.Dest == "" {
+				r.Dest = c.Dest
+			}
+			r.Labels = append(r.Labels, c.Labels...)
+		}
+	}
+	return rf.Rules, nil
+}
+
+// lineOf returns the 1-based line holding byte offset in data.
+func lineOf(data []byte, offset int64) int {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
@@ -0,0 +1,229 @@
+package pdftext
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func writeRules(t *testing.T, name, body string) string {
+	dir, err := ioutil.TempDir("", "rules")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(body), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// newTestProcessor returns a Processor with the rules rs, or the compiled
+// table if rs is nil
+func newTestProcessor(t *testing.T, rs []Rule) *Processor {
+	cfg := DefaultConfig()
+	cfg.Rules = rs
+	p, err := NewProcessor(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestLoadRules(t *testing.T) {
+	bodies := map[string]string{
+		"r.yaml": "rules:\n  - name: Friends-Forest\n    terms: [Friends, forest]\n  - name: Fios\n",
+		"r.toml": "[[rules]]\nname = \"Friends-Forest\"\nterms = [\"Friends\", \"forest\"]\n[[rules]]\nname = \"Fios\"\n",
+		"r.json": `{"rules": [{"name": "Friends-Forest", "terms": ["Friends", "forest"]}, {"name": "Fios"}]}`,
+	}
+	for name, body := range bodies {
+		rs, err := LoadRules(writeRules(t, name, body))
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		p := newTestProcessor(t, rs)
+		if len(p.rules) != 2 || strings.Join(p.rules[0].Terms, ",") != "friends,forest" ||
+			strings.Join(p.rules[1].Terms, ",") != "fios" {
+			t.Errorf("%s: got %v", name, p.rules)
+		}
+		if p.keywords["friends"] == nil || p.keywords["fios"] == nil || len(p.keywords) != 3 {
+			t.Errorf("%s: keywords %v", name, p.keywords)
+		}
+		if rs[0].Terms[0] != "Friends" || rs[1].Terms != nil {
+			t.Errorf("%s: compiling changed the rules to %v", name, rs)
+		}
+	}
+}
+
+var lineThree = regexp.MustCompile(`(line |:)3\b`)
+
+func TestLoadRulesErrors(t *testing.T) {
+	bad := map[string]string{
+		"r.yaml": "rules:\n  - name: A\n    terms: a: b\n",
+		"r.toml": "[[rules]]\nname = \"A\"\nterms = [\"a\"\n",
+		"r.json": "{\"rules\": [\n  {\"name\": \"A\",\n   \"terms\": [\"a\",]}\n]}",
+	}
+	for name, body := range bad {
+		_, err := LoadRules(writeRules(t, name, body))
+		if err == nil || !lineThree.MatchString(err.Error()) {
+			t.Errorf("%s: want an error naming line 3, got %v", name, err)
+		}
+	}
+	if _, err := LoadRules(writeRules(t, "r.json", `{"rules": [{"terms": ["a"]}]}`)); err == nil {
+		t.Error("want an error for a rule without a name")
+	}
+}
+
+func TestRenameBaseExclude(t *testing.T) {
+	p := newTestProcessor(t, rulesFromKeys([][]string{
+		{"Nepenthe-Tax", "yavapai", "treasurer", "!refund"},
+		{"Nepenthe-Tax-Refund", "yavapai", "treasurer", "refund"},
+	}))
+	for text, want := range map[string]string{
+		"Yavapai County Treasurer":              "Nepenthe-Tax.pdf",
+		"Yavapai County Treasurer - Refund due": "Nepenthe-Tax-Refund.pdf",
+	} {
+		tag := OutputTag{OriginalPDF: "in.pdf", NewPDF: "in.pdf", Text: text,
+			Tags: make(map[string]bool), proc: p}
+		tag.matchKeywords()
+		tag.renameBase(ioutil.Discard)
+		if tag.NewPDF != want {
+			t.Errorf("%q: got %s, want %s", text, tag.NewPDF, want)
+		}
+	}
+}
+
+func TestBestRule(t *testing.T) {
+	p := newTestProcessor(t, nil)
+	tag := OutputTag{Text: "Vanguard Group SIMPLE IRA plan www.vanguard.com",
+		Tags: make(map[string]bool), proc: p}
+	tag.matchKeywords()
+	best, runnerUp := p.bestRule(tag.Tags)
+	if best.Rule == nil || best.Rule.Name != "Sharon-SIMPLE" {
+		t.Fatalf("got %+v", best)
+	}
+	if runnerUp.Rule == nil || runnerUp.Rule.Name != "Vanguard" || runnerUp.Score >= best.Score {
+		t.Errorf("runner-up %+v for best score %d", runnerUp, best.Score)
+	}
+}
+
+func TestLintRules(t *testing.T) {
+	rs := []Rule{
+		{Name: "Merrimack-Urology", Terms: []string{"merrimackurology"}},
+		{Name: "Merrimack-Urology", Terms: []string{"MerrimackUrology"}},
+		{Name: "Vanguard", Terms: []string{"vanguard", "simple"}, Priority: 1},
+		{Name: "Sharon-SIMPLE", Terms: []string{"vanguard", "simple", "ira"}},
+		{Name: "Check", Terms: []string{"pay", "o r d e "}},
+		{Name: "Helfman-Lasky"},
+		{Name: "X", Terms: []string{"x", "!"}},
+	}
+	var got []string
+	for _, f := range lintRules(rs) {
+		got = append(got, f.String())
+	}
+	want := []string{
+		`rule 2 (Merrimack-Urology): warning: term "MerrimackUrology" is mixed case but matched lower case; add case: to match it exactly`,
+		`rule 2 (Merrimack-Urology): error: duplicate of rule 1`,
+		`rule 4 (Sharon-SIMPLE): error: never chosen: rule 3 (Vanguard) matches whenever it does and scores 134 to its 47`,
+		`rule 5 (Check): warning: term "o r d e " has leading or trailing space`,
+		`rule 5 (Check): warning: term "o r d e " looks letter-spaced`,
+		`rule 6 (Helfman-Lasky): warning: no terms, so it matches the pieces of its name ["Helfman" "Lasky"]`,
+		`rule 7 (X): error: no usable terms`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestSuggestRules(t *testing.T) {
+	texts := map[string]string{
+		"a.pdf": "Acme Water Company\nQuarterly water bill\nCall 603-555-1212 or visit www.acmewater.com\nMeter reading",
+		"b.pdf": "Acme Water Company\nQuarterly water bill\nCall 603-555-1212 or visit www.acmewater.com\nMeter reading",
+		"c.pdf": "Riverside Dental Group\ncleaning xray patient balance",
+		"d.pdf": "Riverside Dental Group\ncleaning xray patient balance",
+		"e.pdf": "Random letter about gardening tulips",
+	}
+	p := newTestProcessor(t, nil)
+	tags := make(map[string]*OutputTag)
+	for file, text := range texts {
+		tag := &OutputTag{Text: text, Tags: make(map[string]bool), proc: p}
+		tag.processText()
+		tags[file] = tag
+	}
+	var got []string
+	for _, s := range suggestRules(tags) {
+		got = append(got, fmt.Sprint(s.Rule.Name, s.Rule.Terms, s.Files))
+	}
+	want := "Acme-Water-Company[acme water company acmewater.com 603-555-1212] [a.pdf b.pdf]\n" +
+		"Riverside-Dental-Group[riverside dental group balance cleaning] [c.pdf d.pdf]"
+	if strings.Join(got, "\n") != want {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), want)
+	}
+}
+
+func TestRenameBaseDest(t *testing.T) {
+	out, err := ioutil.TempDir("", "dest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(out)
+	os.MkdirAll(filepath.Join(out, "Utilities"), 0755)
+	ioutil.WriteFile(filepath.Join(out, "Utilities", "Fios-2019-Feb-20.pdf"), nil, 0644)
+	rs := []Rule{
+		{Name: "Fios", Terms: []string{"fios"}, Dest: "Utilities"},
+		{Name: "Fios-Tax", Terms: []string{"fios", "tax"}, Dest: "Taxes/{year}", Template: "Fios-{date}"},
+	}
+	p := newTestProcessor(t, rs)
+	var got []string
+	for _, text := range []string{"Fios bill", "Fios bill", "Fios tax"} {
+		tag := OutputTag{OriginalPDF: "in/x.pdf", NewPDF: filepath.Join(out, "x.pdf"),
+			TextFileName: filepath.Join(out, "x.txt"), Text: text, FirstDate: "-2019-Feb-20",
+			Tags: make(map[string]bool), proc: p}
+		tag.matchKeywords()
+		tag.renameBase(ioutil.Discard)
+		rel, _ := filepath.Rel(out, tag.NewPDF)
+		relText, _ := filepath.Rel(out, tag.TextFileName)
+		got = append(got, filepath.ToSlash(rel), filepath.ToSlash(relText))
+	}
+	want := "Utilities/Fios-2019-Feb-20-1.pdf Utilities/Fios-2019-Feb-20-1.txt " +
+		"Utilities/Fios-2019-Feb-20-2.pdf Utilities/Fios-2019-Feb-20-2.txt " +
+		"Taxes/2019/Fios-2019-Feb-20.pdf Taxes/2019/Fios-2019-Feb-20.txt"
+	if strings.Join(got, " ") != want {
+		t.Errorf("got  %s\nwant %s", strings.Join(got, " "), want)
+	}
+}
+
+func TestLoadRulesCategories(t *testing.T) {
+	body := `rules:
+  - name: Rindge-Tax
+    terms: [town of rindge, tax collector]
+    category: Tax
+    labels: [Rindge]
+  - name: Nepenthe-Tax
+    terms: [yavapai, treasurer]
+    category: Tax
+    dest: Arizona
+categories:
+  Tax:
+    dest: Taxes/{year}
+    labels: [Property]
+`
+	rs, err := LoadRules(writeRules(t, "r.yaml", body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := fmt.Sprint(rs[0].Dest, rs[0].Labels, " ", rs[1].Dest, rs[1].Labels)
+	if want := "Taxes/{year}[Rindge Property] Arizona[Property]"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	for _, r := range rulesFromKeys(renameKeys) {
+		if r.Name == "Nepenthe-Tax" && r.Category != "Tax" {
+			t.Errorf("compiled Nepenthe-Tax has category %q", r.Category)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package pdftext
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ruleCase is one sample of a rules test: the base name the expectations
+// file wants and the one the rules produced.
+type ruleCase struct {
+	File string
+	Want string
+	Got  string
+	Tag  *OutputTag
+}
+
+// testRules classifies each sample named in the expectations file, a JSON
+// object mapping file names in dir to expected names without .pdf, which
+// include any directories a template adds:
+//
+//	{"2019_03_02_10_11_12.pdf": "Fios-2019-Feb-20"}
+//
+// Samples may be PDFs or .txt files holding text already extracted, which
+// skips the PDF reader. Each sample is named as if it were the only one, so
+// expectations never carry a -N suffix. Ties are noted on w.
+func (p *Processor) testRules(w io.Writer, dir, expectFile string) ([]ruleCase, error) {
+	data, err := ioutil.ReadFile(expectFile)
+	if err != nil {
+		return nil, err
+	}
+	expect := make(map[string]string)
+	if err := json.Unmarshal(data, &expect); err != nil {
+		return nil, fmt.Errorf("%s: %v", expectFile, err)
+	}
+	var names []string
+	for name := range expect {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var cases []ruleCase
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		ext := filepath.Ext(name)
+		tag := &OutputTag{
+			OriginalPDF: path,
+			NewPDF:      strings.TrimSuffix(path, ext) + ".pdf",
+			Tags:        make(map[string]bool),
+			proc:        p,
+		}
+		if ext == ".txt" {
+			text, err := ioutil.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			tag.Text = string(text)
+			tag.processText()
+		} else if err := tag.processFileAndRename(context.Background()); err != nil {
+			return nil, err
+		}
+		p.mu.Lock()
+		p.newFileNames = make(map[string]map[string]bool)
+		p.mu.Unlock()
+		tag.renameBase(w)
+		got, err := filepath.Rel(dir, tag.NewPDF)
+		if err != nil {
+			return nil, err
+		}
+		cases = append(cases, ruleCase{
+			File: name,
+			Want: expect[name],
+			Got:  filepath.ToSlash(strings.TrimSuffix(got, ".pdf")),
+			Tag:  tag,
+		})
+	}
+	return cases, nil
+}
+
+// runRulesTest runs testRules and writes a line per sample, with the rules
+// involved for each failure. It returns the number of failures.
+func (p *Processor) runRulesTest(w io.Writer, dir, expectFile string) (int, error) {
+	cases, err := p.testRules(w, dir, expectFile)
+	if err != nil {
+		return 0, err
+	}
+	failed := 0
+	for _, c := range cases {
+		if c.Got == c.Want {
+			fmt.Fprintf(w, "ok   %s: %s\n", c.File, c.Got)
+			continue
+		}
+		failed++
+		fmt.Fprintf(w, "FAIL %s\n", c.File)
+		fmt.Fprintf(w, "  - %s\n  + %s\n", c.Want, c.Got)
+		if best, _ := p.bestRule(c.Tag.Tags); best.Rule != nil {
+			fmt.Fprintf(w, "  chosen %s, score %d\n", best.Rule.Name, best.Score)
+		}
+		if c.Tag.RunnerUp != "" {
+			fmt.Fprintf(w, "  runner-up %s, score %d\n", c.Tag.RunnerUp, c.Tag.RunnerUpScore)
+		}
+		var hits []string
+		for k := range c.Tag.Tags {
+			hits = append(hits, k)
+		}
+		sort.Strings(hits)
+		fmt.Fprintf(w, "  terms found %q\n", hits)
+	}
+	fmt.Fprintf(w, "%d passed, %d failed\n", len(cases)-failed, failed)
+	return failed, nil
+}
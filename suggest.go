@@ -0,0 +1,310 @@
+package pdftext
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// Tuning for rules suggest
+const (
+	suggestMinDocs    = 2   // Smallest cluster worth a rule
+	suggestSimilarity = 0.4 // Cosine similarity needed to join a cluster
+	suggestVector     = 20  // Distinctive words kept per document
+	suggestTerms      = 3   // Terms proposed per rule
+	suggestHeaderRows = 6   // Lines searched for an organisation name
+)
+
+var (
+	domainRE = regexp.MustCompile(`(?i)\b(?:www\.)?[a-z0-9][a-z0-9-]*(?:\.[a-z0-9-]+)*\.(?:com|org|net|gov|edu|us)\b`)
+	phoneRE  = regexp.MustCompile(`\(?\b\d{3}\)?[-. ]\d{3}[-. ]\d{4}\b`)
+	orgRE    = regexp.MustCompile(`(?i)\b(inc|llc|bank|company|co|corporation|insurance|association|department|hospital|society|group|services|town|county|credit union|university)\b`)
+)
+
+// suggestDoc is an unmatched document from tags.json with its tf-idf vector
+// and the names and numbers found in it.
+type suggestDoc struct {
+	File     string
+	Words    map[string]bool
+	Vector   map[string]float64
+	Entities []string // Organisations, domains then phone numbers
+}
+
+// suggestion is a proposed rule and the documents it was drawn from.
+type suggestion struct {
+	Rule  Rule
+	Files []string
+}
+
+// suggestRules clusters the documents in tags that no rule renamed by their
+// most distinctive words and proposes a rule for each cluster: terms common
+// to every document, preferring organisation names, domains and phone
+// numbers, then words by tf-idf.
+func suggestRules(tags map[string]*OutputTag) []suggestion {
+	var docs []*suggestDoc
+	df := make(map[string]int)
+	for file, tag := range tags {
+		if tag.Renamed || len(tag.Words) == 0 {
+			continue
+		}
+		doc := &suggestDoc{File: file, Words: make(map[string]bool)}
+		for _, w := range tag.Words {
+			if alnumCount(w) >= 3 && strings.IndexFunc(w, unicode.IsLetter) >= 0 {
+				doc.Words[w] = true
+				df[w]++
+			}
+		}
+		doc.Entities = findEntities(tag.Text)
+		docs = append(docs, doc)
+	}
+	sort.Slice(docs, func(i, j int) bool { return docs[i].File < docs[j].File })
+
+	// Words in one document do not link it to others, and words in most
+	// of them do not tell them apart.
+	n := float64(len(docs))
+	idf := func(w string) float64 {
+		return math.Log(n / float64(df[w]))
+	}
+	for _, doc := range docs {
+		var ws []string
+		for w := range doc.Words {
+			if df[w] >= 2 && float64(df[w]) <= n/2 {
+				ws = append(ws, w)
+			}
+		}
+		sort.Slice(ws, func(i, j int) bool {
+			if idf(ws[i]) != idf(ws[j]) {
+				return idf(ws[i]) > idf(ws[j])
+			}
+			return ws[i] < ws[j]
+		})
+		if len(ws) > suggestVector {
+			ws = ws[:suggestVector]
+		}
+		doc.Vector = make(map[string]float64)
+		for _, w := range ws {
+			doc.Vector[w] = idf(w)
+		}
+	}
+
+	// Single pass leader clustering against each cluster's summed vector
+	type cluster struct {
+		docs     []*suggestDoc
+		centroid map[string]float64
+	}
+	var clusters []*cluster
+	for _, doc := range docs {
+		var best *cluster
+		bestSim := suggestSimilarity
+		for _, c := range clusters {
+			if sim := cosine(doc.Vector, c.centroid); sim >= bestSim {
+				best, bestSim = c, sim
+			}
+		}
+		if best == nil {
+			best = &cluster{centroid: make(map[string]float64)}
+			clusters = append(clusters, best)
+		}
+		best.docs = append(best.docs, doc)
+		for w, v := range doc.Vector {
+			best.centroid[w] += v
+		}
+	}
+
+	var out []suggestion
+	for _, c := range clusters {
+		if len(c.docs) < suggestMinDocs {
+			continue
+		}
+		inAll := func(has func(*suggestDoc) bool) bool {
+			for _, doc := range c.docs {
+				if !has(doc) {
+					return false
+				}
+			}
+			return true
+		}
+		var terms []string
+		for _, e := range c.docs[0].Entities {
+			if len(terms) < suggestTerms && inAll(func(d *suggestDoc) bool { return contains(d.Entities, e) }) {
+				terms = append(terms, e)
+			}
+		}
+		var words []string
+		for w := range c.centroid {
+			if inAll(func(d *suggestDoc) bool { return d.Words[w] }) {
+				words = append(words, w)
+			}
+		}
+		sort.Slice(words, func(i, j int) bool {
+			if c.centroid[words[i]] != c.centroid[words[j]] {
+				return c.centroid[words[i]] > c.centroid[words[j]]
+			}
+			return words[i] < words[j]
+		})
+		for _, w := range words {
+			if len(terms) >= suggestTerms {
+				break
+			}
+			if !covered(terms, w) {
+				terms = append(terms, w)
+			}
+		}
+		if len(terms) == 0 {
+			continue
+		}
+		s := suggestion{Rule: Rule{Name: suggestName(terms), Terms: terms}}
+		for _, doc := range c.docs {
+			s.Files = append(s.Files, doc.File)
+		}
+		out = append(out, s)
+	}
+	sort.SliceStable(out, func(i, j int) bool { return len(out[i].Files) > len(out[j].Files) })
+	return out
+}
+
+// findEntities returns the organisation names in the first lines of text,
+// then its domains and phone numbers, lower cased and without repeats.
+func findEntities(text string) []string {
+	var found []string
+	add := func(s string) {
+		s = strings.ToLower(strings.Join(strings.Fields(s), " "))
+		if s != "" && !contains(found, s) {
+			found = append(found, s)
+		}
+	}
+	rows := strings.Split(text, "\n")
+	if len(rows) > suggestHeaderRows {
+		rows = rows[:suggestHeaderRows]
+	}
+	for _, row := range rows {
+		fields := strings.Fields(row)
+		if len(fields) >= 2 && len(fields) <= 6 && orgRE.MatchString(row) && capitalized(fields) {
+			add(row)
+		}
+	}
+	for _, d := range domainRE.FindAllString(text, -1) {
+		add(strings.TrimPrefix(strings.ToLower(d), "www."))
+	}
+	for _, p := range phoneRE.FindAllString(text, -1) {
+		add(p)
+	}
+	return found
+}
+
+// capitalized reports whether most fields start with an upper case letter,
+// as the words of a letterhead do.
+func capitalized(fields []string) bool {
+	n := 0
+	for _, f := range fields {
+		if r := []rune(f); unicode.IsUpper(r[0]) {
+			n++
+		}
+	}
+	return n*2 > len(fields)
+}
+
+// suggestName makes a rule name from the first term: the words of an
+// organisation, the main label of a domain, or the word itself.
+func suggestName(terms []string) string {
+	t := terms[0]
+	if domainRE.MatchString(t) && !strings.Contains(t, " ") {
+		labels := strings.Split(t, ".")
+		t = labels[len(labels)-2]
+	}
+	var parts []string
+	for _, f := range strings.FieldsFunc(t, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		parts = append(parts, strings.ToUpper(f[:1])+f[1:])
+		if len(parts) == 3 {
+			break
+		}
+	}
+	if len(parts) == 0 {
+		return "Suggested"
+	}
+	return strings.Join(parts, "-")
+}
+
+// covered reports whether w is already part of one of the terms.
+func covered(terms []string, w string) bool {
+	for _, t := range terms {
+		if strings.Contains(t, w) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
+
+func cosine(a, b map[string]float64) float64 {
+	var dot, na, nb float64
+	for w, v := range a {
+		dot += v * b[w]
+		na += v * v
+	}
+	for _, v := range b {
+		nb += v * v
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(na*nb)
+}
+
+// writeSuggestions writes the suggested rules as a rules file, in the format
+// given by the extension of path. YAML output notes the documents behind
+// each rule. The file has permissions perm.
+func writeSuggestions(path string, suggestions []suggestion, perm os.FileMode) error {
+	var rf RuleFile
+	for _, s := range suggestions {
+		rf.Rules = append(rf.Rules, s.Rule)
+	}
+	var buf bytes.Buffer
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		fmt.Fprintln(&buf, "# Suggested by pdftext rules suggest; review before use")
+		fmt.Fprintln(&buf, "rules:")
+		for _, s := range suggestions {
+			out, err := yaml.Marshal([]Rule{s.Rule})
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(&buf, "# %d documents: %s\n", len(s.Files), strings.Join(s.Files, ", "))
+			buf.Write(out)
+		}
+	case ".toml":
+		if err := toml.NewEncoder(&buf).Encode(rf); err != nil {
+			return err
+		}
+	case ".json":
+		out, err := json.MarshalIndent(rf, "", " ")
+		if err != nil {
+			return err
+		}
+		buf.Write(out)
+	default:
+		return fmt.Errorf("%s: unknown rules format %q, want .yaml, .toml or .json",
+			path, filepath.Ext(path))
+	}
+	return writeFileAtomic(path, buf.Bytes(), perm)
+}
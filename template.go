@@ -0,0 +1,160 @@
+package pdftext
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultTemplate names files as the rule name and the first date,
+// e.g. Fios-2019-Feb-20, as pdftext always has.
+const defaultTemplate = "{rule}-{date}"
+
+// placeholderRE matches {name} and {name:layout}
+var placeholderRE = regexp.MustCompile(`\{(\w+)(?::([^}]*))?\}`)
+
+var amountRE = regexp.MustCompile(`\$\s?(\d{1,3}(?:,\d{3})*|\d+)\.(\d\d)\b`)
+
+// firstDateLayout is the layout of OutputTag.FirstDate
+const firstDateLayout = "-2006-Jan-2"
+
+// checkTemplate reports unknown or malformed placeholders in tmpl.
+func checkTemplate(tmpl string) error {
+	if strings.Count(tmpl, "{") != len(placeholderRE.FindAllString(tmpl, -1)) {
+		return fmt.Errorf("%q: unbalanced braces", tmpl)
+	}
+	for _, m := range placeholderRE.FindAllStringSubmatch(tmpl, -1) {
+		switch m[1] {
+		case "rule", "date", "isodate", "year", "month", "day", "base", "pages", "amount":
+		default:
+			return fmt.Errorf("%q: unknown placeholder {%s}", tmpl, m[1])
+		}
+		if m[2] != "" && m[1] != "date" {
+			return fmt.Errorf("%q: {%s} takes no layout", tmpl, m[1])
+		}
+	}
+	if filepath.IsAbs(tmpl) || strings.Contains("/"+tmpl+"/", "/../") {
+		return fmt.Errorf("%q: must stay within the output directory", tmpl)
+	}
+	return nil
+}
+
+// newName is the name, without extension, the rule gives the tag's file,
+// built from the rule's template or else --template, and placed under the
+// rule's Dest directory if it has one. Templates have these placeholders:
+//
+//	{rule}         the rule name
+//	{date}         the first date, as 2006-Jan-2
+//	{date:LAYOUT}  the first date in a Go time layout, e.g. {date:2006-01-02}
+//	{isodate}      the first date as 2006-01-02, which sorts by date
+//	{year} {month} {day}  parts of the first date, as 2006 01 02
+//	{base}         the original file name without .pdf
+//	{pages}        the page count
+//	{amount}       the first dollar amount in the text, e.g. 1234.56
+//
+// Dest is a template too, e.g. Medical/{year}, and a "/" in the name
+// template also files the PDF in a subdirectory of --output; a "/" in a
+// layout or a value becomes "_". Placeholders with no value are dropped
+// along with the separator before them, so an undated file is named Fios
+// rather than Fios-.
+func (tag *OutputTag) newName(rule *Rule) string {
+	tmpl := tag.proc.cfg.Template
+	if rule.Template != "" {
+		tmpl = rule.Template
+	}
+	name := tag.expand(tmpl, rule)
+	if name == "" {
+		name = rule.Name
+	}
+	if dir := tag.expand(rule.Dest, rule); dir != "" {
+		name = dir + "/" + name
+	}
+	return name
+}
+
+// expand fills in the placeholders of tmpl, returning "" if nothing but
+// separators is left.
+func (tag *OutputTag) expand(tmpl string, rule *Rule) string {
+	date, dateErr := time.Parse(firstDateLayout, tag.FirstDate)
+	hasDate := tag.FirstDate != "" && dateErr == nil
+	value := func(name, layout string) string {
+		switch name {
+		case "rule":
+			return rule.Name
+		case "base":
+			return strings.TrimSuffix(filepath.Base(tag.OriginalPDF), filepath.Ext(tag.OriginalPDF))
+		case "pages":
+			if tag.Pages == 0 {
+				return ""
+			}
+			return strconv.Itoa(tag.Pages)
+		case "amount":
+			if m := amountRE.FindStringSubmatch(tag.Text); m != nil {
+				return strings.Replace(m[1], ",", "", -1) + "." + m[2]
+			}
+			return ""
+		}
+		if !hasDate {
+			return ""
+		}
+		switch name {
+		case "date":
+			if layout == "" {
+				layout = firstDateLayout[1:]
+			}
+			return date.Format(layout)
+		case "isodate":
+			return date.Format("2006-01-02")
+		case "year":
+			return date.Format("2006")
+		case "month":
+			return date.Format("01")
+		case "day":
+			return date.Format("02")
+		}
+		return ""
+	}
+
+	// Only a "/" outside the placeholders separates directories; one in
+	// a layout or a value is part of the name
+	var parts []string
+	var b strings.Builder
+	endPart := func() {
+		// A value of . or .. must not become a directory
+		if p := strings.Trim(b.String(), "-_ "); p != "" && p != "." && p != ".." {
+			parts = append(parts, p)
+		}
+		b.Reset()
+	}
+	writeLiteral := func(literal string) {
+		for i, s := range strings.Split(literal, "/") {
+			if i > 0 {
+				endPart()
+			}
+			b.WriteString(s)
+		}
+	}
+	last := 0
+	for _, loc := range placeholderRE.FindAllStringSubmatchIndex(tmpl, -1) {
+		literal := tmpl[last:loc[0]]
+		layout := ""
+		if loc[4] >= 0 {
+			layout = tmpl[loc[4]:loc[5]]
+		}
+		v := value(tmpl[loc[2]:loc[3]], layout)
+		v = strings.NewReplacer("/", "_", string(filepath.Separator), "_").Replace(v)
+		if v == "" {
+			// Drop the separator that would have led into the value
+			literal = strings.TrimRight(literal, "-_ .")
+		}
+		writeLiteral(literal)
+		b.WriteString(v)
+		last = loc[1]
+	}
+	writeLiteral(tmpl[last:])
+	endPart()
+	return strings.Join(parts, "/")
+}
@@ -0,0 +1,49 @@
+package pdftext
+
+import "testing"
+
+func TestNewName(t *testing.T) {
+	p := &Processor{}
+	dated := &OutputTag{proc: p, OriginalPDF: "in/2019_02_20_10_11_12.pdf", FirstDate: "-2019-Feb-20",
+		Pages: 3, Text: "Amount due $1,234.56 by Feb 20, 2019"}
+	undated := &OutputTag{proc: p, OriginalPDF: "in/scan.pdf"}
+	tests := []struct {
+		global, rule string
+		tag          *OutputTag
+		want         string
+	}{
+		{defaultTemplate, "", dated, "Fios-2019-Feb-20"},
+		{defaultTemplate, "", undated, "Fios"},
+		{"{rule}-{date:2006-01-02}", "", dated, "Fios-2019-02-20"},
+		{defaultTemplate, "{rule}/{year}/{rule}-{isodate}-{amount}", dated, "Fios/2019/Fios-2019-02-20-1234.56"},
+		{"{rule}/{year}/{rule}-{isodate}-{amount}", "", undated, "Fios/Fios"},
+		{"{base}-{rule}-{pages}p", "", dated, "2019_02_20_10_11_12-Fios-3p"},
+		{"{rule}-{date:01/02/2006}", "", dated, "Fios-02_20_2019"},
+		{"{rule}/{date:2006/01}/{isodate}", "", dated, "Fios/2019_02/2019-02-20"},
+	}
+	for _, test := range tests {
+		if err := checkTemplate(test.global); err != nil {
+			t.Fatal(err)
+		}
+		p.cfg.Template = test.global
+		rule := &Rule{Name: "Fios", Template: test.rule}
+		if got := test.tag.newName(rule); got != test.want {
+			t.Errorf("%q/%q: got %s, want %s", test.global, test.rule, got, test.want)
+		}
+	}
+	// A rule or original name of .. does not become a directory
+	p.cfg.Template = "{rule}/{isodate}"
+	if got := dated.newName(&Rule{Name: ".."}); got != "2019-02-20" {
+		t.Errorf("rule ..: got %s", got)
+	}
+	p.cfg.Template = "{base}/{rule}"
+	dots := &OutputTag{proc: p, OriginalPDF: "in/...pdf"}
+	if got := dots.newName(&Rule{Name: "Fios"}); got != "Fios" {
+		t.Errorf("base ..: got %s", got)
+	}
+	for _, bad := range []string{"{rule", "{colour}", "{year:06}", "../{rule}", "/tmp/{rule}"} {
+		if checkTemplate(bad) == nil {
+			t.Errorf("%q: want an error", bad)
+		}
+	}
+}